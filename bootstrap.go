@@ -0,0 +1,267 @@
+package golibtox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Node is a bootstrap node, as listed in the nodes.json format of
+// https://nodes.tox.chat/json.
+type Node struct {
+	IPv4       string `json:"ipv4"`
+	IPv6       string `json:"ipv6"`
+	Port       uint16 `json:"port"`
+	PublicKey  string `json:"public_key"`
+	Maintainer string `json:"maintainer,omitempty"`
+	Location   string `json:"location,omitempty"`
+}
+
+type nodeList struct {
+	Nodes []struct {
+		Node
+		// Absent from hand written lists
+		StatusUDP *bool `json:"status_udp"`
+	} `json:"nodes"`
+}
+
+// ParseNodes reads a nodes.json document from r. Nodes known to be down,
+// or without a valid IPv4 address or public key, are left out.
+func ParseNodes(r io.Reader) ([]Node, error) {
+	var list nodeList
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var nodes []Node
+	for _, n := range list.Nodes {
+		if n.IPv4 == "" || n.IPv4 == "-" || n.Port == 0 {
+			continue
+		}
+		if _, err := ParsePublicKey(n.PublicKey); err != nil {
+			continue
+		}
+		if n.StatusUDP != nil && !*n.StatusUDP {
+			continue
+		}
+		nodes = append(nodes, n.Node)
+	}
+
+	return nodes, nil
+}
+
+// LoadNodes reads a nodes.json file.
+func LoadNodes(path string) ([]Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseNodes(f)
+}
+
+// FetchNodes downloads a nodes.json document from url, such as
+// https://nodes.tox.chat/json.
+func FetchNodes(ctx context.Context, url string) ([]Node, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrFetchNodes
+	}
+
+	return ParseNodes(resp.Body)
+}
+
+// Bootstrapper keeps t connected to the DHT from a list of nodes.
+type Bootstrapper struct {
+	tox *Tox
+	// Nodes bootstrapped from at once
+	count int
+	// Delay before bootstrapping again while not connected
+	timeout time.Duration
+
+	mtx     sync.Mutex
+	nodes   []*NodeStats
+	tried   []*NodeStats // Last bootstrapped from, not known to work yet
+	lastTry time.Time
+	pending bool
+}
+
+// NodeStats tells how a node did with a Bootstrapper. A node is counted
+// as successful when bootstrapping from it, among others, connected t,
+// and as failed when its address could not be resolved or t was still
+// not connected after the timeout.
+type NodeStats struct {
+	Node      Node
+	Successes int
+	Failures  int
+	LastUsed  time.Time
+}
+
+// NewBootstrapper returns a Bootstrapper using count nodes at a time, and
+// trying others once t is still not connected after timeout. count and
+// timeout must be positive.
+func NewBootstrapper(t *Tox, nodes []Node, count int, timeout time.Duration) (*Bootstrapper, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("%w: bootstrap node count must be at least 1", ErrInvalidOptions)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("%w: bootstrap timeout must be positive", ErrInvalidOptions)
+	}
+
+	b := &Bootstrapper{
+		tox:     t,
+		count:   count,
+		timeout: timeout,
+	}
+	for _, n := range nodes {
+		b.nodes = append(b.nodes, &NodeStats{Node: n})
+	}
+
+	return b, nil
+}
+
+// Bootstrap bootstraps t from count random nodes, the ones that worked
+// before first.
+func (b *Bootstrapper) Bootstrap() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.bootstrap()
+}
+
+// bootstrap does Bootstrap. b.mtx must be held.
+func (b *Bootstrapper) bootstrap() error {
+	if len(b.nodes) == 0 {
+		return ErrNoNodes
+	}
+
+	candidates := make([]*NodeStats, len(b.nodes))
+	copy(candidates, b.nodes)
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Successes-candidates[i].Failures > candidates[j].Successes-candidates[j].Failures
+	})
+
+	now := time.Now()
+	b.tried = nil
+	b.lastTry = now
+	b.pending = true
+
+	var err error
+	for _, n := range candidates {
+		if len(b.tried) == b.count {
+			break
+		}
+
+		n.LastUsed = now
+		if err = b.tox.BootstrapFromAddress(n.Node.IPv4, n.Node.Port, n.Node.PublicKey); err != nil {
+			if err != ErrBootstrap {
+				return err
+			}
+			n.Failures++
+			continue
+		}
+		if n.Node.IPv6 != "" && n.Node.IPv6 != "-" && b.tox.ipv6Enabled {
+			b.tox.BootstrapFromAddress(n.Node.IPv6, n.Node.Port, n.Node.PublicKey)
+		}
+		b.tried = append(b.tried, n)
+	}
+
+	if len(b.tried) == 0 {
+		return err
+	}
+	return nil
+}
+
+// Check updates the node stats from whether t is connected, and
+// bootstraps again if t is still not connected after the timeout.
+func (b *Bootstrapper) Check() error {
+	connected, err := b.tox.IsConnected()
+	if err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if connected {
+		if b.pending {
+			for _, n := range b.tried {
+				n.Successes++
+			}
+			b.pending = false
+		}
+		return nil
+	}
+
+	if time.Since(b.lastTry) < b.timeout {
+		return nil
+	}
+
+	if b.pending {
+		for _, n := range b.tried {
+			n.Failures++
+		}
+	}
+
+	return b.bootstrap()
+}
+
+// Run bootstraps t, then calls Check every second until ctx is done or t
+// is closed.
+func (b *Bootstrapper) Run(ctx context.Context) error {
+	if err := b.Bootstrap(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := b.Check()
+			if err == ErrClosed {
+				return nil
+			}
+			if err != nil && err != ErrBootstrap {
+				return err
+			}
+		}
+	}
+}
+
+// Stats returns the stats of the nodes, in the order they were given.
+func (b *Bootstrapper) Stats() []NodeStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	stats := make([]NodeStats, len(b.nodes))
+	for i, n := range b.nodes {
+		stats[i] = *n
+	}
+
+	return stats
+}
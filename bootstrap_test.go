@@ -0,0 +1,82 @@
+package golibtox
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadNodes(t *testing.T) {
+	nodes, err := LoadNodes("testdata/nodes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Node{
+		{
+			IPv4:       "192.254.75.102",
+			IPv6:       "2607:5600:284::2",
+			Port:       33445,
+			PublicKey:  "951C88B7E75C867418ACDB5D273821372BB5BD652740BCDF623A4FA293E75D2F",
+			Maintainer: "stqism",
+			Location:   "US",
+		},
+		{
+			IPv4:       "144.76.60.215",
+			IPv6:       "-",
+			Port:       33445,
+			PublicKey:  "04119E835DF3E78BACF0F84235B300546AF8B936F035185E2A8E9E0A67C8924F",
+			Maintainer: "sonOfRa",
+			Location:   "DE",
+		},
+		{
+			IPv4:       "198.46.136.167",
+			IPv6:       "-",
+			Port:       33445,
+			PublicKey:  "728925473812C7AAC482BE7250BCCAD0B8CB9F737BF3D42ABD34459C1768F854",
+			Maintainer: "hand written",
+		},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("LoadNodes = %+v, want %+v", nodes, want)
+	}
+}
+
+func TestParseNodesInvalid(t *testing.T) {
+	for _, doc := range []string{"", "{", `{"nodes": {}}`, `{"nodes": [{"port": "33445"}]}`} {
+		if _, err := ParseNodes(strings.NewReader(doc)); err == nil {
+			t.Errorf("ParseNodes(%q): no error", doc)
+		}
+	}
+
+	nodes, err := ParseNodes(strings.NewReader(`{"nodes": []}`))
+	if err != nil || len(nodes) != 0 {
+		t.Errorf("ParseNodes of no nodes = %v, %v", nodes, err)
+	}
+}
+
+func TestNewBootstrapperInvalid(t *testing.T) {
+	nodes := []Node{{IPv4: "127.0.0.1", Port: 33445}}
+
+	tests := []struct {
+		count   int
+		timeout time.Duration
+	}{
+		{0, time.Minute},
+		{-1, time.Minute},
+		{1, 0},
+		{1, -time.Second},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewBootstrapper(nil, nodes, tt.count, tt.timeout); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("NewBootstrapper(%d, %v) = %v, want %v", tt.count, tt.timeout, err, ErrInvalidOptions)
+		}
+	}
+
+	if _, err := NewBootstrapper(nil, nodes, 1, time.Minute); err != nil {
+		t.Errorf("NewBootstrapper(1, 1m) = %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package golibtox
+
+type callbackKind int
+
+const (
+	cbFriendRequest callbackKind = iota
+	cbFriendMessage
+	cbFriendAction
+	cbNameChange
+	cbStatusMessage
+	cbUserStatus
+	cbTypingChange
+	cbReadReceipt
+	cbConnectionStatus
+	cbFileSendRequest
+	cbFileControl
+	cbFileData
+	cbGroupInvite
+	cbGroupMessage
+	cbGroupAction
+	cbGroupNamelistChange
+	cbSelfConnectionStatus
+)
+
+// callback wraps a registered func so that it can be found again by
+// identity when removed, func values not being comparable.
+type callback struct {
+	f interface{}
+}
+
+func (t *Tox) addCallback(kind callbackKind, f interface{}) func() {
+	cb := &callback{f}
+
+	t.cbmtx.Lock()
+	if t.callbacks == nil {
+		t.callbacks = make(map[callbackKind][]*callback)
+	}
+	t.callbacks[kind] = append(t.callbacks[kind], cb)
+	t.cbmtx.Unlock()
+
+	return func() {
+		t.removeCallback(kind, cb)
+	}
+}
+
+func (t *Tox) removeCallback(kind callbackKind, cb *callback) {
+	t.cbmtx.Lock()
+	defer t.cbmtx.Unlock()
+
+	cbs := t.callbacks[kind]
+	for i, c := range cbs {
+		if c == cb {
+			// Copy so that handlers() snapshots are never modified
+			t.callbacks[kind] = append(cbs[:i:i], cbs[i+1:]...)
+			return
+		}
+	}
+}
+
+// handlers returns the funcs registered for kind, in registration order.
+func (t *Tox) handlers(kind callbackKind) []interface{} {
+	t.cbmtx.Lock()
+	defer t.cbmtx.Unlock()
+
+	cbs := t.callbacks[kind]
+	fs := make([]interface{}, len(cbs))
+	for i, cb := range cbs {
+		fs[i] = cb.f
+	}
+
+	return fs
+}
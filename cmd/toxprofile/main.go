@@ -0,0 +1,55 @@
+// Command toxprofile prints a profile saved by toxcore as JSON, without
+// needing toxcore.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/organ/golibtox/toxstate"
+)
+
+func main() {
+	var secret bool
+
+	flag.BoolVar(&secret, "secret", false, "also print the secret key")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: toxprofile [-secret] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	profile, err := toxstate.Decode(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var v interface{} = profile
+	if secret {
+		v = struct {
+			*toxstate.Profile
+			SecretKey toxstate.Key
+		}{profile, profile.SecretKey}
+	}
+
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
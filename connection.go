@@ -0,0 +1,69 @@
+package golibtox
+
+import "time"
+
+// ConnectionStats tells how t has been connected to the DHT, as seen by
+// Do.
+type ConnectionStats struct {
+	Connected bool
+	// Time of the last connection or disconnection, zero if none yet
+	Since time.Time
+	// Creation of t
+	Started time.Time
+	// Zero until the first connection
+	FirstConnected     time.Time
+	TimeToFirstConnect time.Duration
+	Connects           int
+	Disconnects        int
+}
+
+// CallbackSelfConnectionStatus adds f to the funcs called by Do when t
+// connects to or disconnects from the DHT.
+func (t *Tox) CallbackSelfConnectionStatus(f SelfConnectionStatusFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	return t.addCallback(cbSelfConnectionStatus, f)
+}
+
+func (t *Tox) ConnectionStats() (ConnectionStats, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return ConnectionStats{}, t.nilError()
+	}
+
+	return t.conn, nil
+}
+
+// checkConnection records whether t is connected after tox_do, queueing
+// the callbacks on a change. t.mtx must be held.
+func (t *Tox) checkConnection(connected bool) {
+	if connected == t.conn.Connected {
+		return
+	}
+
+	now := time.Now()
+	t.conn.Connected = connected
+	t.conn.Since = now
+	if connected {
+		t.conn.Connects++
+		if t.conn.FirstConnected.IsZero() {
+			t.conn.FirstConnected = now
+			t.conn.TimeToFirstConnect = now.Sub(t.conn.Started)
+		}
+	} else {
+		t.conn.Disconnects++
+	}
+
+	t.queue(func() {
+		for _, f := range t.handlers(cbSelfConnectionStatus) {
+			f.(SelfConnectionStatusFunc)(connected, now)
+		}
+		t.emit(SelfConnectionStatusEvent{connected, now})
+	})
+}
@@ -13,6 +13,13 @@ const (
 	FRIEND_ADDRESS_SIZE      = C.TOX_FRIEND_ADDRESS_SIZE
 )
 
+// Filenumbers available per friend and direction, from Messenger.h which
+// tox.h does not expose.
+const MAX_CONCURRENT_FILE_PIPES = 256
+
+// toxcore binds the first free UDP port between PORTRANGE_FROM and
+// PORTRANGE_TO. The range is fixed when toxcore is built: this version of
+// Tox_Options cannot change it, so neither can NewWithOptions.
 const (
 	PORTRANGE_FROM = C.TOX_PORTRANGE_FROM
 	PORTRANGE_TO   = C.TOX_PORTRANGE_TO
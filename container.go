@@ -0,0 +1,101 @@
+package golibtox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// Profile container written by SaveTo and WriteProfile:
+//
+//	magic    [4]byte "gtox"
+//	version  uint16
+//	length   uint32
+//	data     [length]byte
+//	checksum uint32, CRC-32 (IEEE) of everything before it
+//
+// Integers are big endian.
+const (
+	PROFILE_VERSION = 1
+
+	profileHeaderSize = 10
+)
+
+var profileMagic = []byte("gtox")
+
+// SaveTo writes the state of t to w, in a container detecting corruption.
+func (t *Tox) SaveTo(w io.Writer) error {
+	data, err := t.Save()
+	if err != nil {
+		return err
+	}
+
+	return WriteProfile(w, data)
+}
+
+// LoadFrom loads a state written by SaveTo from r.
+func (t *Tox) LoadFrom(r io.Reader) error {
+	data, err := ReadProfile(r)
+	if err != nil {
+		return err
+	}
+
+	return t.Load(data)
+}
+
+// WriteProfile writes data, as returned by Save or SaveEncrypted, to w
+// in a profile container.
+func WriteProfile(w io.Writer, data []byte) error {
+	buf := make([]byte, profileHeaderSize, profileHeaderSize+len(data)+4)
+	copy(buf, profileMagic)
+	binary.BigEndian.PutUint16(buf[4:], PROFILE_VERSION)
+	binary.BigEndian.PutUint32(buf[6:], uint32(len(data)))
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// ReadProfile returns the data of the profile container read from r. It
+// fails with ErrProfileFormat, ErrProfileVersion, ErrProfileTruncated or
+// ErrProfileChecksum if r does not hold a complete and valid container.
+func ReadProfile(r io.Reader) ([]byte, error) {
+	header := make([]byte, profileHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF && !bytes.HasPrefix(profileMagic, header[:min(n, len(profileMagic))]) {
+			return nil, ErrProfileFormat
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrProfileTruncated
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], profileMagic) {
+		return nil, ErrProfileFormat
+	}
+	if binary.BigEndian.Uint16(header[4:]) != PROFILE_VERSION {
+		return nil, ErrProfileVersion
+	}
+
+	// Grows with what is actually read, whatever the length says
+	length := int64(binary.BigEndian.Uint32(header[6:]))
+	rest, err := io.ReadAll(io.LimitReader(r, length+4))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(rest)) < length+4 {
+		return nil, ErrProfileTruncated
+	}
+
+	data, checksum := rest[:length], rest[length:]
+	crc := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, data)
+	if binary.BigEndian.Uint32(checksum) != crc {
+		return nil, ErrProfileChecksum
+	}
+
+	return data, nil
+}
@@ -0,0 +1,88 @@
+package golibtox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func writeTestProfile(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	if err := WriteProfile(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestProfileRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{{}, []byte("state"), bytes.Repeat([]byte{0xff}, 4096)} {
+		got, err := ReadProfile(bytes.NewReader(writeTestProfile(t, data)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("ReadProfile = %d bytes, %v, want %d bytes", len(got), err, len(data))
+		}
+	}
+}
+
+func TestReadProfileInvalid(t *testing.T) {
+	profile := writeTestProfile(t, []byte("some state"))
+	modified := func(i int, b byte) []byte {
+		p := bytes.Clone(profile)
+		p[i] = b
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		profile []byte
+		err     error
+	}{
+		{"empty", nil, ErrProfileTruncated},
+		{"partial magic", profile[:2], ErrProfileTruncated},
+		{"partial header", profile[:7], ErrProfileTruncated},
+		{"header only", profile[:profileHeaderSize], ErrProfileTruncated},
+		{"partial data", profile[:profileHeaderSize+4], ErrProfileTruncated},
+		{"no checksum", profile[:len(profile)-4], ErrProfileTruncated},
+		{"partial checksum", profile[:len(profile)-1], ErrProfileTruncated},
+		{"short other file", []byte("{}"), ErrProfileFormat},
+		{"raw state", []byte("\x00\x00\x00\x00\x1f\x1b\xed\x15"), ErrProfileFormat},
+		{"wrong magic", append([]byte("xtox"), profile[4:]...), ErrProfileFormat},
+		{"newer version", modified(5, PROFILE_VERSION+1), ErrProfileVersion},
+		{"longer length", modified(9, profile[9]+1), ErrProfileTruncated},
+		{"shorter length", modified(9, profile[9]-1), ErrProfileChecksum},
+	}
+
+	for _, tt := range tests {
+		if _, err := ReadProfile(bytes.NewReader(tt.profile)); err != tt.err {
+			t.Errorf("%s: ReadProfile = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestReadProfileBitFlip(t *testing.T) {
+	profile := writeTestProfile(t, []byte("some state"))
+
+	for i := range profile {
+		for bit := 0; bit < 8; bit++ {
+			p := bytes.Clone(profile)
+			p[i] ^= 1 << bit
+
+			_, err := ReadProfile(bytes.NewReader(p))
+			if err == nil {
+				t.Fatalf("byte %d bit %d flipped: no error", i, bit)
+			}
+			want := ErrProfileChecksum
+			switch {
+			case i < 4:
+				want = ErrProfileFormat
+			case i < 6:
+				want = ErrProfileVersion
+			case i < profileHeaderSize && err == ErrProfileTruncated:
+				// A longer length
+				want = ErrProfileTruncated
+			}
+			if err != want {
+				t.Errorf("byte %d bit %d flipped: %v, want %v", i, bit, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package golibtox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// DigestError is the error of an incoming transfer whose SHA-256 digest,
+// sent by the friend with FILECONTROL_FINISHED, does not match the data
+// received.
+type DigestError struct {
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("File digest mismatch: expected %x, got %x", e.Expected, e.Actual)
+}
+
+// SetTransferDigests sets whether the files sent by SendFile from now on
+// carry the SHA-256 digest of their content in the data of their
+// FILECONTROL_FINISHED. Incoming transfers sent with a digest are always
+// checked, a mismatch killing them with a *DigestError; clients that do
+// not send one are not affected.
+func (t *Tox) SetTransferDigests(enabled bool) {
+	t.trmtx.Lock()
+	t.sendDigests = enabled
+	t.trmtx.Unlock()
+}
+
+// Verified reports whether an incoming transfer has been checked against
+// the digest sent by the friend.
+func (tr *Transfer) Verified() bool {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.verified
+}
+
+// digest returns the data of the FILECONTROL_FINISHED closing an outgoing
+// transfer. tr.mtx must be held.
+func (tr *Transfer) digest() []byte {
+	if tr.hash == nil {
+		return nil
+	}
+
+	return tr.hash.Sum(nil)
+}
+
+// verify checks the data of the FILECONTROL_FINISHED closing an incoming
+// transfer. tr.mtx must be held.
+func (tr *Transfer) verify(data []byte) error {
+	if len(data) != sha256.Size {
+		return nil
+	}
+
+	sum := tr.hash.Sum(nil)
+	if !bytes.Equal(sum, data) {
+		return &DigestError{Expected: data, Actual: sum}
+	}
+	tr.verified = true
+
+	return nil
+}
+
+// rehash hashes again the first position bytes of an outgoing transfer
+// resumed from there, leaving its reader at position.
+// tr.mtx must be held.
+func (tr *Transfer) rehash(seeker io.Seeker, position uint64) error {
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tr.hash.Reset()
+	_, err := io.CopyN(tr.hash, tr.r, int64(position))
+
+	return err
+}
@@ -0,0 +1,326 @@
+package golibtox
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+type dirEntry struct {
+	path string
+	hdr  *tar.Header
+}
+
+// SendDirectory sends the directories and regular files under dir to
+// friendNumber as a single tar archive named after dir, gzipped if
+// compress is true. Symbolic links and special files are left out.
+//
+// The archive is streamed while it is sent, so the transfer cannot be
+// resumed once broken. Its size is announced to the friend only when it
+// is not compressed, 0 meaning unknown.
+func (t *Tox) SendDirectory(ctx context.Context, friendNumber int32, dir string, compress bool) (*Transfer, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, size, err := walkDirectory(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Base(dir) + ".tar"
+	if compress {
+		filename += ".gz"
+		size = 0
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeDirectory(pw, entries, compress))
+	}()
+
+	tr, err := t.SendFile(ctx, friendNumber, filename, pr, size)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+
+	// Stop writing the archive if the transfer ends early
+	go func() {
+		<-tr.done
+		pr.Close()
+	}()
+
+	return tr, nil
+}
+
+// walkDirectory returns the entries of the archive of dir, and its size
+// once not compressed.
+func walkDirectory(dir string) ([]dirEntry, uint64, error) {
+	var entries []dirEntry
+	var size int64
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, p)
+		if err != nil || name == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		// Owners mean nothing on the friend's machine
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+
+		n, err := tarHeaderSize(hdr)
+		if err != nil {
+			return err
+		}
+		// Contents are padded to 512 byte blocks
+		size += n + (hdr.Size+511)&^511
+
+		entries = append(entries, dirEntry{p, hdr})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// End of archive: two zero blocks
+	size += 1024
+
+	return entries, uint64(size), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// tarHeaderSize returns the size of hdr once written by a tar.Writer,
+// including any PAX extended header.
+func tarHeaderSize(hdr *tar.Header) (int64, error) {
+	var w countingWriter
+	if err := tar.NewWriter(&w).WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+
+	return w.n, nil
+}
+
+func writeDirectory(w io.Writer, entries []dirEntry, compress bool) error {
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			return err
+		}
+		if e.hdr.Typeflag == tar.TypeReg {
+			if err := copyFile(tw, e.path, e.hdr.Size); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if zw != nil {
+		return zw.Close()
+	}
+
+	return nil
+}
+
+// copyFile writes the size first bytes of the file at p to w, the size
+// announced in its header.
+func copyFile(w io.Writer, p string, size int64) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(w, f, size)
+
+	return err
+}
+
+type directoryWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// ReceiveDirectory returns the writer an AcceptFileFunc gives for tr to
+// extract the tar archive it receives, gzipped or not, into dir. Entries
+// with an absolute path or a ".." element fail the transfer with
+// ErrUnsafePath; links and special files are skipped. An extraction
+// cannot continue from the middle of the archive: one partially received
+// by a previous run, see SetTransferStateFile, is received again from
+// its start and Offset is reset to 0.
+func ReceiveDirectory(tr *Transfer, dir string) io.WriteCloser {
+	tr.restart()
+
+	pr, pw := io.Pipe()
+	w := &directoryWriter{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+
+		w.err = extractDirectory(pr, dir)
+		if w.err != nil {
+			// Fail the next writes, killing the transfer
+			pr.CloseWithError(w.err)
+			return
+		}
+		// Data past the end of the archive is ignored
+		io.Copy(io.Discard, pr)
+	}()
+
+	return w
+}
+
+func (w *directoryWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close waits for the end of the extraction and returns its error.
+func (w *directoryWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+
+	return w.err
+}
+
+func extractDirectory(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	src := io.Reader(br)
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		// Only the archive, the sender writes a single stream
+		zr.Multistream(false)
+		src = zr
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Also keeps the files within dir through links already there
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := extractName(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = root.MkdirAll(name, 0755)
+		case tar.TypeReg:
+			err = extractFile(root, name, tr, hdr.FileInfo().Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Read the end of the gzip stream, checking it
+	_, err = io.Copy(io.Discard, src)
+
+	return err
+}
+
+// extractName returns the path, relative to the extraction directory, of
+// the archive entry name.
+func extractName(name string) (string, error) {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", ErrUnsafePath
+		}
+	}
+
+	name = path.Clean(name)
+	if path.IsAbs(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", ErrUnsafePath
+	}
+
+	return filepath.FromSlash(name), nil
+}
+
+func extractFile(root *os.Root, name string, r io.Reader, perm fs.FileMode) error {
+	if dir := filepath.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
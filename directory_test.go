@@ -0,0 +1,148 @@
+package golibtox
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"file", "file", nil},
+		{"dir/", "dir", nil},
+		{"a/b/c", filepath.Join("a", "b", "c"), nil},
+		{"./a", "a", nil},
+		{"../x", "", ErrUnsafePath},
+		{"..", "", ErrUnsafePath},
+		{"/etc/x", "", ErrUnsafePath},
+		{"a/../../x", "", ErrUnsafePath},
+		{"a/../b", "", ErrUnsafePath},
+	}
+
+	for _, tt := range tests {
+		got, err := extractName(tt.name)
+		if got != tt.want || err != tt.err {
+			t.Errorf("extractName(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestExtractUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  tar.Header
+	}{
+		{"parent", tar.Header{Name: "../x", Typeflag: tar.TypeReg, Size: 1, Mode: 0644}},
+		{"absolute", tar.Header{Name: "/etc/x", Typeflag: tar.TypeReg, Size: 1, Mode: 0644}},
+		{"nested parent", tar.Header{Name: "a/../../x", Typeflag: tar.TypeReg, Size: 1, Mode: 0644}},
+		{"directory", tar.Header{Name: "../d/", Typeflag: tar.TypeDir, Mode: 0755}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&tt.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if tt.hdr.Size > 0 {
+			tw.Write([]byte("x"))
+		}
+		tw.Close()
+
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "out")
+		if err := extractDirectory(&buf, dir); err != ErrUnsafePath {
+			t.Errorf("%s: extractDirectory = %v, want %v", tt.name, err, ErrUnsafePath)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "x")); err == nil {
+			t.Errorf("%s: file written outside of the directory", tt.name)
+		}
+	}
+}
+
+func TestExtractSkipsLinks(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tw.WriteHeader(&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"})
+	tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 2, Mode: 0644})
+	tw.Write([]byte("ok"))
+	tw.Close()
+
+	dir := t.TempDir()
+	if err := extractDirectory(&buf, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"link", "hard"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s extracted", name)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "file")); err != nil || string(b) != "ok" {
+		t.Errorf("file = %q, %v", b, err)
+	}
+}
+
+func TestDirectoryRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	long := strings.Repeat("x", 150)
+	os.MkdirAll(filepath.Join(src, "a", "b"), 0755)
+	os.MkdirAll(filepath.Join(src, "empty"), 0755)
+	os.WriteFile(filepath.Join(src, "a", "b", long), bytes.Repeat([]byte("hi"), 1000), 0644)
+	os.WriteFile(filepath.Join(src, "top"), []byte("top"), 0600)
+	os.WriteFile(filepath.Join(src, "void"), nil, 0644)
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, size, err := walkDirectory(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeDirectory(&buf, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(buf.Len()) != size {
+		t.Errorf("announced size %d, wrote %d bytes", size, buf.Len())
+	}
+
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := writeDirectory(&buf, entries, compress); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := t.TempDir()
+		if err := extractDirectory(&buf, dst); err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+
+		files := map[string]int{
+			"top":                         3,
+			"void":                        0,
+			filepath.Join("a", "b", long): 2000,
+		}
+		for name, n := range files {
+			b, err := os.ReadFile(filepath.Join(dst, name))
+			if err != nil || len(b) != n {
+				t.Errorf("compress %v: %s: %d bytes, %v, want %d", compress, name, len(b), err, n)
+			}
+		}
+		if fi, err := os.Stat(filepath.Join(dst, "empty")); err != nil || !fi.IsDir() {
+			t.Errorf("compress %v: empty directory not extracted: %v", compress, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "link")); err == nil {
+			t.Errorf("compress %v: link sent", compress)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package golibtox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Attempts at finding a free name for a download before refusing it.
+const maxDownloadSuffix = 1000
+
+// SanitizeFilename returns the name a file sent as filename can be saved
+// under: its last path element, trimmed of spaces. It fails with
+// ErrInvalidFilename if filename is not valid UTF-8, has control
+// characters or is left empty.
+func SanitizeFilename(filename []byte) (string, error) {
+	if !utf8.Valid(filename) {
+		return "", ErrInvalidFilename
+	}
+
+	name := string(filename)
+	if strings.IndexFunc(name, unicode.IsControl) != -1 {
+		return "", ErrInvalidFilename
+	}
+
+	// Windows separators too, whatever this system uses
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." {
+		return "", ErrInvalidFilename
+	}
+
+	return name, nil
+}
+
+// Downloader saves incoming files to a directory. Its Accept method is an
+// AcceptFileFunc.
+type Downloader struct {
+	dir   string
+	quota uint64
+
+	mtx sync.Mutex
+	// Announced bytes of the running downloads not written yet
+	pending uint64
+}
+
+// NewDownloader returns a Downloader saving files to dir, created if
+// needed. If quota is not 0, files are refused once the size of dir plus
+// the announced size of the running downloads would exceed it.
+func NewDownloader(dir string, quota uint64) (*Downloader, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Downloader{dir: dir, quota: quota}, nil
+}
+
+func (d *Downloader) Dir() string {
+	return d.dir
+}
+
+// Accept creates the file receiving tr in the directory of d, under its
+// sanitized name, adding a " (n)" suffix if it is taken. It returns nil,
+// refusing the file, if the name is invalid or the quota is exceeded; with
+// a quota, files of unknown size are refused too. The path of the file is
+// then given by tr.Path.
+//
+// A file partially received by a previous run, see SetTransferStateFile,
+// is written on at Offset in the same file if it is still there, or
+// received again from its start otherwise.
+func (d *Downloader) Accept(tr *Transfer) io.Writer {
+	name, err := SanitizeFilename(tr.Filename())
+	if err != nil {
+		return nil
+	}
+
+	if tr.Offset() > 0 {
+		if w := d.resume(tr); w != nil {
+			return w
+		}
+		tr.restart()
+	}
+
+	if !d.reserve(tr.Size()) {
+		return nil
+	}
+
+	f, err := d.create(name)
+	if err != nil {
+		d.release(tr.Size())
+		return nil
+	}
+
+	tr.restart()
+	tr.path = f.Name()
+
+	return &download{d: d, f: f, reserved: tr.Size()}
+}
+
+// resume reopens the file of tr recorded by a previous run, truncated to
+// what was recorded as received. It returns nil if it cannot.
+func (d *Downloader) resume(tr *Transfer) io.Writer {
+	// Only a file saved by a Downloader of the same directory
+	if tr.path == "" || filepath.Dir(tr.path) != filepath.Clean(d.dir) {
+		return nil
+	}
+
+	remaining := tr.Size() - tr.Offset()
+	if !d.reserve(remaining) {
+		return nil
+	}
+
+	f, err := os.OpenFile(tr.path, os.O_WRONLY, 0)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil && uint64(fi.Size()) < tr.Offset() {
+			err = io.ErrUnexpectedEOF
+		}
+		// Drop what was written after the last record
+		if err == nil {
+			err = f.Truncate(int64(tr.Offset()))
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		d.release(remaining)
+		return nil
+	}
+
+	return &download{d: d, f: f, reserved: remaining}
+}
+
+// reserve accounts for a download of size bytes, if the quota allows it.
+func (d *Downloader) reserve(size uint64) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.quota > 0 {
+		if size == 0 {
+			return false
+		}
+		used, err := dirSize(d.dir)
+		if err != nil || used+d.pending+size > d.quota {
+			return false
+		}
+	}
+	d.pending += size
+
+	return true
+}
+
+func (d *Downloader) release(n uint64) {
+	d.mtx.Lock()
+	d.pending -= n
+	d.mtx.Unlock()
+}
+
+// create opens a new file named name in the directory of d, or name with
+// the first free suffix.
+func (d *Downloader) create(name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; i < maxDownloadSuffix; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+
+		f, err := os.OpenFile(filepath.Join(d.dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+
+	return nil, fs.ErrExist
+}
+
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+
+	return size, err
+}
+
+// download is the writer given by Downloader.Accept. It refuses more
+// bytes than announced when the Downloader has a quota.
+type download struct {
+	d        *Downloader
+	f        *os.File
+	reserved uint64
+	written  uint64
+}
+
+func (w *download) Write(p []byte) (int, error) {
+	if w.d.quota > 0 && w.written+uint64(len(p)) > w.reserved {
+		return 0, ErrQuotaExceeded
+	}
+
+	n, err := w.f.Write(p)
+	prev := w.written
+	w.written += uint64(n)
+	if prev < w.reserved {
+		w.d.release(min(w.written, w.reserved) - prev)
+	}
+
+	return n, err
+}
+
+func (w *download) Close() error {
+	w.d.release(w.reserved - min(w.written, w.reserved))
+
+	return w.f.Close()
+}
@@ -0,0 +1,40 @@
+package golibtox
+
+import "testing"
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+		err      error
+	}{
+		{"file.txt", "file.txt", nil},
+		{"  spaced name  ", "spaced name", nil},
+		{"été.png", "été.png", nil},
+		{"dir/file", "file", nil},
+		{"../../etc/passwd", "passwd", nil},
+		{"/etc/passwd", "passwd", nil},
+		{`C:\Users\me\file`, "file", nil},
+		{`..\..\file`, "file", nil},
+		{"..", "", ErrInvalidFilename},
+		{".", "", ErrInvalidFilename},
+		{"a/..", "", ErrInvalidFilename},
+		{"dir/", "", ErrInvalidFilename},
+		{"", "", ErrInvalidFilename},
+		{"   ", "", ErrInvalidFilename},
+		{"new\nline", "", ErrInvalidFilename},
+		{"nul\x00", "", ErrInvalidFilename},
+		{"esc\x1b[31m", "", ErrInvalidFilename},
+		{"del\x7f", "", ErrInvalidFilename},
+		{"c1\u0085", "", ErrInvalidFilename},
+		{"bad\xff", "", ErrInvalidFilename},
+		{"\xc3", "", ErrInvalidFilename},
+	}
+
+	for _, tt := range tests {
+		got, err := SanitizeFilename([]byte(tt.filename))
+		if got != tt.want || err != tt.err {
+			t.Errorf("SanitizeFilename(%q) = %q, %v, want %q, %v", tt.filename, got, err, tt.want, tt.err)
+		}
+	}
+}
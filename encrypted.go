@@ -0,0 +1,12 @@
+package golibtox
+
+import "bytes"
+
+// Magic number starting the data saved by toxencryptsave.
+var encryptedMagic = []byte("toxEsave")
+
+// IsEncrypted reports whether data was saved by SaveEncrypted, and must be
+// loaded by LoadEncrypted instead of Load.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
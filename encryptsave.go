@@ -0,0 +1,60 @@
+//go:build toxencryptsave
+
+package golibtox
+
+/*
+#cgo LDFLAGS: -ltoxencryptsave
+
+#include <tox/toxencryptsave.h>
+*/
+import "C"
+
+// SaveEncrypted returns the state of t, like Save, encrypted by
+// toxencryptsave with a key derived from passphrase with scrypt.
+// It needs the toxencryptsave build tag.
+func (t *Tox) SaveEncrypted(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return nil, t.nilError()
+	}
+
+	size := C.tox_encrypted_size(t.tox)
+	data := make([]byte, size)
+	ret := C.tox_encrypted_save(t.tox, (*C.uint8_t)(&data[0]), (*C.uint8_t)(&passphrase[0]), (C.uint32_t)(len(passphrase)))
+
+	if ret == -1 {
+		return nil, ErrEncryptedSave
+	}
+	return data, nil
+}
+
+// LoadEncrypted loads data saved by SaveEncrypted with passphrase.
+func (t *Tox) LoadEncrypted(data []byte, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrEmptyPassphrase
+	}
+
+	if !IsEncrypted(data) {
+		return ErrNotEncrypted
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
+	}
+
+	ret := C.tox_encrypted_load(t.tox, (*C.uint8_t)(&data[0]), (C.uint32_t)(len(data)), (*C.uint8_t)(&passphrase[0]), (C.uint32_t)(len(passphrase)))
+
+	if ret == -1 {
+		return ErrEncryptedLoad
+	}
+	return nil
+}
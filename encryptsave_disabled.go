@@ -0,0 +1,15 @@
+//go:build !toxencryptsave
+
+package golibtox
+
+// SaveEncrypted needs golibtox to be built with the toxencryptsave tag,
+// linking libtoxencryptsave. Without it, it returns ErrNoEncryption.
+func (t *Tox) SaveEncrypted(passphrase []byte) ([]byte, error) {
+	return nil, ErrNoEncryption
+}
+
+// LoadEncrypted needs golibtox to be built with the toxencryptsave tag,
+// linking libtoxencryptsave. Without it, it returns ErrNoEncryption.
+func (t *Tox) LoadEncrypted(data []byte, passphrase []byte) error {
+	return ErrNoEncryption
+}
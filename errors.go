@@ -0,0 +1,83 @@
+package golibtox
+
+import "errors"
+
+var (
+	ErrNotInitialized       = errors.New("Tox not initialized")
+	ErrClosed               = errors.New("Tox closed")
+	ErrInvalidAddress       = errors.New("Invalid friend address")
+	ErrInvalidClientId      = errors.New("Invalid client id")
+	ErrFriendNotFound       = errors.New("Friend not found")
+	ErrFriendNotConnected   = errors.New("Friend not connected")
+	ErrEmptyMessage         = errors.New("Empty message")
+	ErrMessageTooLong       = errors.New("Message too long")
+	ErrSendFailed           = errors.New("Error sending message")
+	ErrEmptyFilename        = errors.New("Empty filename")
+	ErrFilenameTooLong      = errors.New("Filename too long")
+	ErrNoFileSlot           = errors.New("No free file transfer slot")
+	ErrFileControl          = errors.New("Error sending file control")
+	ErrFileSendData         = errors.New("Error sending file data")
+	ErrTransferKilled       = errors.New("File transfer killed by friend")
+	ErrTransferCanceled     = errors.New("File transfer canceled")
+	ErrNotResumable         = errors.New("File transfer reader cannot seek to resume")
+	ErrTransferBroken       = errors.New("File transfer broken for too long")
+	ErrEmptyData            = errors.New("Empty data")
+	ErrLoad                 = errors.New("Error loading data")
+	ErrUnsafePath           = errors.New("Unsafe path in directory archive")
+	ErrInvalidFilename      = errors.New("Invalid filename")
+	ErrQuotaExceeded        = errors.New("Download quota exceeded")
+	ErrEmptyPassphrase      = errors.New("Empty passphrase")
+	ErrNotEncrypted         = errors.New("Data not encrypted")
+	ErrEncryptedSave        = errors.New("Error encrypting data")
+	ErrEncryptedLoad        = errors.New("Wrong passphrase or error loading data")
+	ErrNoEncryption         = errors.New("Built without the toxencryptsave tag")
+	ErrProfileFormat        = errors.New("Not a profile container")
+	ErrProfileVersion       = errors.New("Unsupported profile version")
+	ErrProfileTruncated     = errors.New("Truncated profile")
+	ErrProfileChecksum      = errors.New("Profile checksum mismatch")
+	ErrInvalidProfile       = errors.New("Invalid profile document")
+	ErrWrongPassphrase      = errors.New("Wrong passphrase")
+	ErrBootstrap            = errors.New("Error resolving bootstrap node address")
+	ErrNoNodes              = errors.New("No bootstrap nodes")
+	ErrFetchNodes           = errors.New("Error fetching bootstrap nodes")
+	ErrInvalidOptions       = errors.New("Invalid options")
+	ErrInit                 = errors.New("Error initializing Tox")
+	ErrAddFriend            = errors.New("Error adding friend")
+	ErrEmptyName            = errors.New("Empty name")
+	ErrNameTooLong          = errors.New("Name too long")
+	ErrSetName              = errors.New("Error setting name")
+	ErrSelfName             = errors.New("Error retrieving self name")
+	ErrStatusMessageTooLong = errors.New("Status message too long")
+	ErrSetStatusMessage     = errors.New("Error setting status message")
+	ErrSelfStatusMessage    = errors.New("Error retrieving self status message")
+	ErrInvalidUserStatus    = errors.New("Invalid user status")
+	ErrFileNotFound         = errors.New("File transfer not found")
+	ErrAddGroupchat         = errors.New("Error creating groupchat")
+	ErrGroupNotFound        = errors.New("Groupchat not found")
+	ErrPeerNotFound         = errors.New("Groupchat peer not found")
+	ErrInviteFriend         = errors.New("Error inviting friend to groupchat")
+	ErrInvalidGroupKey      = errors.New("Invalid group public key")
+	ErrJoinGroupchat        = errors.New("Error joining groupchat")
+	ErrGroupSendFailed      = errors.New("Error sending group message")
+	ErrInvalidRate          = errors.New("Negative send rate")
+)
+
+func (e FriendAddError) Error() string {
+	switch e {
+	case FAERR_TOOLONG:
+		return "Friend request message too long"
+	case FAERR_NOMESSAGE:
+		return "Friend request message empty"
+	case FAERR_OWNKEY:
+		return "Friend address is our own"
+	case FAERR_ALREADYSENT:
+		return "Friend request already sent or friend already added"
+	case FAERR_BADCHECKSUM:
+		return "Friend address checksum mismatch"
+	case FAERR_SETNEWNOSPAM:
+		return "Friend already added with a different nospam"
+	case FAERR_NOMEM:
+		return "Could not allocate memory for friend request"
+	}
+	return "Error adding friend"
+}
@@ -0,0 +1,244 @@
+package golibtox
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is implemented by every event type delivered by Events.
+type Event interface {
+	event()
+}
+
+type FriendRequestEvent struct {
+	PublicKey PublicKey
+	Data      []byte
+}
+
+type FriendMessageEvent struct {
+	FriendNumber int32
+	Message      []byte
+}
+
+type FriendActionEvent struct {
+	FriendNumber int32
+	Action       []byte
+}
+
+type NameChangeEvent struct {
+	FriendNumber int32
+	NewName      []byte
+}
+
+type StatusMessageEvent struct {
+	FriendNumber int32
+	NewStatus    []byte
+}
+
+type UserStatusEvent struct {
+	FriendNumber int32
+	Status       UserStatus
+}
+
+type TypingChangeEvent struct {
+	FriendNumber int32
+	IsTyping     bool
+}
+
+type ReadReceiptEvent struct {
+	FriendNumber int32
+	Receipt      uint32
+}
+
+type ConnectionStatusEvent struct {
+	FriendNumber int32
+	Status       bool
+}
+
+type FileSendRequestEvent struct {
+	FriendNumber int32
+	Filenumber   uint8
+	Filesize     uint64
+	Filename     []byte
+}
+
+type FileControlEvent struct {
+	FriendNumber int32
+	Sending      bool
+	Filenumber   uint8
+	FileControl  FileControl
+	Data         []byte
+}
+
+type FileDataEvent struct {
+	FriendNumber int32
+	Filenumber   uint8
+	Data         []byte
+}
+
+type GroupInviteEvent struct {
+	FriendNumber   int32
+	GroupPublicKey []byte
+}
+
+type GroupMessageEvent struct {
+	GroupNumber       int
+	FriendGroupNumber int
+	Message           []byte
+}
+
+type GroupActionEvent struct {
+	GroupNumber       int
+	FriendGroupNumber int
+	Action            []byte
+}
+
+type GroupNamelistChangeEvent struct {
+	GroupNumber int
+	PeerNumber  int
+	Change      ChatChange
+}
+
+type SelfConnectionStatusEvent struct {
+	Connected bool
+	Time      time.Time
+}
+
+func (FriendRequestEvent) event()        {}
+func (FriendMessageEvent) event()        {}
+func (FriendActionEvent) event()         {}
+func (NameChangeEvent) event()           {}
+func (StatusMessageEvent) event()        {}
+func (UserStatusEvent) event()           {}
+func (TypingChangeEvent) event()         {}
+func (ReadReceiptEvent) event()          {}
+func (ConnectionStatusEvent) event()     {}
+func (FileSendRequestEvent) event()      {}
+func (FileControlEvent) event()          {}
+func (FileDataEvent) event()             {}
+func (GroupInviteEvent) event()          {}
+func (GroupMessageEvent) event()         {}
+func (GroupActionEvent) event()          {}
+func (GroupNamelistChangeEvent) event()  {}
+func (SelfConnectionStatusEvent) event() {}
+
+// OverflowPolicy tells what happens to an event when a subscriber's
+// channel is full.
+type OverflowPolicy int
+
+const (
+	// The new event is discarded.
+	OVERFLOW_DROP_NEWEST OverflowPolicy = iota
+	// The oldest buffered event is discarded to make room.
+	OVERFLOW_DROP_OLDEST
+	// Do blocks until the subscriber reads the event.
+	OVERFLOW_BLOCK
+)
+
+type eventSubscriber struct {
+	ch     chan Event
+	policy OverflowPolicy
+	mtx    sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+}
+
+// Events returns a channel receiving every event of t, buffered to size,
+// and a function closing it. Each call creates a new independent
+// subscription; callbacks registered with the Callback* methods keep working.
+// The channel is closed by Close, or right away if t is closed already.
+func (t *Tox) Events(size int, policy OverflowPolicy) (<-chan Event, func()) {
+	s := &eventSubscriber{
+		ch:     make(chan Event, size),
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		s.close()
+		return s.ch, func() {}
+	}
+
+	t.evmtx.Lock()
+	t.subscribers = append(t.subscribers, s)
+	t.evmtx.Unlock()
+
+	t.setHooks()
+
+	return s.ch, func() {
+		t.unsubscribe(s)
+	}
+}
+
+func (t *Tox) unsubscribe(s *eventSubscriber) {
+	t.evmtx.Lock()
+	for i, sub := range t.subscribers {
+		if sub == s {
+			t.subscribers = append(t.subscribers[:i:i], t.subscribers[i+1:]...)
+			break
+		}
+	}
+	t.evmtx.Unlock()
+
+	s.close()
+}
+
+func (t *Tox) emit(e Event) {
+	t.evmtx.Lock()
+	subscribers := t.subscribers
+	t.evmtx.Unlock()
+
+	for _, s := range subscribers {
+		s.send(e)
+	}
+}
+
+func (s *eventSubscriber) send(e Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	switch s.policy {
+	case OVERFLOW_BLOCK:
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		}
+	case OVERFLOW_DROP_OLDEST:
+		for {
+			select {
+			case s.ch <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *eventSubscriber) close() {
+	s.once.Do(func() {
+		// Unblock a pending OVERFLOW_BLOCK send before taking the lock
+		close(s.done)
+
+		s.mtx.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mtx.Unlock()
+	})
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"os/signal"
 	"time"
@@ -13,47 +13,50 @@ import (
 	"github.com/organ/golibtox"
 )
 
-type Server struct {
-	Address   string
-	Port      uint16
-	PublicKey string
-}
-
 func main() {
-	var filepath string
-
-	// Map of active file transfers
-	transfers := make(map[uint8]*os.File)
+	var filepath, passphrase, nodesPath string
 
 	flag.StringVar(&filepath, "save", "", "path to save file")
+	flag.StringVar(&passphrase, "passphrase", "", "passphrase encrypting the save file")
+	flag.StringVar(&nodesPath, "nodes", "", "path to a nodes.json file")
 	flag.Parse()
 
-	server := &Server{"37.187.46.132", 33445, "A9D98212B3F972BD11DA52BEB0658C326FCCC1BFD49F347F9C2D3D8B61E1B927"}
+	nodes := []golibtox.Node{
+		{IPv4: "37.187.46.132", Port: 33445, PublicKey: "A9D98212B3F972BD11DA52BEB0658C326FCCC1BFD49F347F9C2D3D8B61E1B927"},
+	}
+	if len(nodesPath) > 0 {
+		var err error
+		if nodes, err = golibtox.LoadNodes(nodesPath); err != nil {
+			panic(err)
+		}
+	}
 
 	tox, err := golibtox.New()
 	if err != nil {
 		panic(err)
 	}
 
+	// Keep the last 3 profiles
+	store := golibtox.NewProfileStore(filepath, 3)
+
 	// If no data could be loaded, we should set the name
-	if err := loadData(tox, filepath); err != nil {
+	if err := loadData(tox, store, passphrase); err != nil {
 		tox.SetName("GolibtoxBot")
 	}
 
 	tox.SetStatusMessage([]byte("golibtox is cool!"))
 
 	addr, _ := tox.GetAddress()
-	fmt.Println("ID: ", hex.EncodeToString(addr))
+	fmt.Println("ID: ", addr)
 
 	err = tox.SetUserStatus(golibtox.USERSTATUS_NONE)
 
-	tox.CallbackFriendRequest(func(pubkey []byte, data []byte, length uint16) {
-		fmt.Printf("New friend request from %s\n", hex.EncodeToString(pubkey))
+	tox.CallbackFriendRequest(func(pubkey golibtox.PublicKey, data []byte, length uint16) {
+		fmt.Printf("New friend request from %s\n", pubkey)
 		fmt.Printf("With message: %v\n", string(data))
 
 		// Auto-accept friend request
-		clientId := pubkey[:golibtox.CLIENT_ID_SIZE]
-		tox.AddFriendNorequest(clientId)
+		tox.AddFriendNorequest(pubkey)
 	})
 
 	tox.CallbackFriendMessage(func(friendNumber int32, message []byte, length uint16) {
@@ -85,92 +88,106 @@ func main() {
 		fmt.Printf("Got read receipt %d from %d\n", receipt, friendNumber)
 	})
 
+	tox.CallbackSelfConnectionStatus(func(connected bool, at time.Time) {
+		if connected {
+			stats, _ := tox.ConnectionStats()
+			fmt.Printf("Connected to the DHT, %v after start\n", at.Sub(stats.Started).Round(time.Second))
+		} else {
+			fmt.Println("Disconnected from the DHT")
+		}
+	})
+
 	tox.CallbackConnectionStatus(func(friendNumber int32, status bool) {
 		fmt.Printf("New connection status from %d : %v\n", friendNumber, status)
 	})
 
-	tox.CallbackFileSendRequest(func(friendNumber int32, filenumber uint8, filesize uint64, filename []byte, filenameLength uint16) {
-		// Accept any file send request
-		tox.FileSendControl(friendNumber, true, filenumber, golibtox.FILECONTROL_ACCEPT, nil)
-		// Init *File handle
-		f, _ := os.Create("example_" + string(filename))
-		// Append f to the map[uint8]*os.File
-		transfers[filenumber] = f
-	})
+	downloads, err := golibtox.NewDownloader("example_downloads", 1<<30)
+	if err != nil {
+		panic(err)
+	}
 
-	tox.CallbackFileControl(func(friendNumber int32, sending bool, filenumber uint8, fileControl golibtox.FileControl, data []byte, length uint16) {
-		// Finished receiving file
-		if fileControl == golibtox.FILECONTROL_FINISHED {
-			f := transfers[filenumber]
-			f.Sync()
-			f.Close()
-			delete(transfers, filenumber)
-			fmt.Println("Written file", filenumber)
-			tox.SendMessage(friendNumber, []byte("Thanks!"))
+	tox.AcceptFile(func(tr *golibtox.Transfer) io.Writer {
+		// Accept any file fitting in the quota, closed by golibtox once received
+		w := downloads.Accept(tr)
+		if w == nil {
+			fmt.Printf("Refused file %q\n", tr.Filename())
+			return nil
 		}
-	})
 
-	tox.CallbackFileData(func(friendNumber int32, filenumber uint8, data []byte, length uint16) {
-		// Write data to the hopefully valid *File handle
-		if f, exists := transfers[filenumber]; exists {
-			f.Write(data)
-		}
+		go func() {
+			if err := tr.Wait(); err != nil {
+				fmt.Println("Error receiving file", tr.Path(), err)
+				return
+			}
+			fmt.Println("Written file", tr.Path())
+			tox.SendMessage(tr.FriendNumber(), []byte("Thanks!"))
+		}()
+
+		return w
 	})
 
-	err = tox.BootstrapFromAddress(server.Address, server.Port, server.PublicKey)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Bootstrap from 4 nodes, others if still not connected after a minute
+	bootstrapper, err := golibtox.NewBootstrapper(tox, nodes, 4, time.Minute)
 	if err != nil {
 		panic(err)
 	}
+	go func() {
+		if err := bootstrapper.Run(ctx); err != nil {
+			fmt.Println("Error bootstrapping:", err)
+		}
+	}()
 
-	isRunning := true
+	save := func(data []byte) error {
+		fmt.Println("Saving...")
+		return saveData(tox, store, passphrase, data)
+	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	ticker := time.NewTicker(25 * time.Millisecond)
+	// Save new friends right away instead of only on exit
+	if len(filepath) > 0 {
+		tox.SetAutosave(save, 5*time.Second)
+	}
 
-	for isRunning {
-		select {
-		case <-c:
-			fmt.Println("Saving...")
-			if err := saveData(tox, filepath); err != nil {
-				fmt.Println(err)
-			}
-			fmt.Println("Killing")
-			isRunning = false
-			tox.Kill()
-			break
-		case <-ticker.C:
-			tox.Do()
-			break
-		}
+	err = tox.Run(ctx, save)
+	if err != nil {
+		fmt.Println(err)
 	}
+	fmt.Println("Killed")
 }
 
-func loadData(t *golibtox.Tox, filepath string) error {
-	if len(filepath) == 0 {
+func loadData(t *golibtox.Tox, store *golibtox.ProfileStore, passphrase string) error {
+	if len(store.Path()) == 0 {
 		return errors.New("Empty path")
 	}
 
-	data, err := ioutil.ReadFile(filepath)
+	data, err := store.Load()
 	if err != nil {
 		return err
 	}
 
+	if golibtox.IsEncrypted(data) {
+		return t.LoadEncrypted(data, []byte(passphrase))
+	}
+
 	err = t.Load(data)
 
 	return err
 }
 
-func saveData(t *golibtox.Tox, filepath string) error {
-	if len(filepath) == 0 {
+func saveData(t *golibtox.Tox, store *golibtox.ProfileStore, passphrase string, data []byte) error {
+	if len(store.Path()) == 0 {
 		return errors.New("Empty path")
 	}
 
-	data, err := t.Save()
-	if err != nil {
-		return err
+	if len(passphrase) > 0 {
+		var err error
+		if data, err = t.SaveEncrypted([]byte(passphrase)); err != nil {
+			return err
+		}
 	}
 
-	err = ioutil.WriteFile(filepath, data, 0644)
-	return err
+	// Written only readable by us, the profile holds the private key
+	return store.Save(data)
 }
@@ -7,52 +7,101 @@ package golibtox
 
 #include <tox/tox.h>
 #include <stdlib.h>
+#include <stdint.h>
 
 // Convenient macro:
-// Creates the C function to directly register a given callback
-#define HOOK(x) \
-static void set_##x(Tox *tox, void *t) { \
-	tox_##x(tox, hook_##x, t); \
-}
-
-void hook_callback_friend_request(Tox*, uint8_t*, uint8_t*, uint16_t, void*);
-void hook_callback_friend_message(Tox*, int32_t, uint8_t*, uint16_t, void*);
-void hook_callback_friend_action(Tox*, int32_t, uint8_t*, uint16_t, void*);
-void hook_callback_name_change(Tox*, int32_t, uint8_t*, uint16_t, void*);
-void hook_callback_status_message(Tox*, int32_t, uint8_t*, uint16_t, void*);
-void hook_callback_user_status(Tox*, int32_t, uint8_t, void*);
-void hook_callback_typing_change(Tox*, int32_t, uint8_t, void*);
-void hook_callback_read_receipt(Tox*, int32_t, uint32_t, void*);
-void hook_callback_connection_status(Tox*, int32_t, uint8_t, void*);
-void hook_callback_file_send_request(Tox*, int32_t, uint8_t, uint64_t, uint8_t*, uint16_t, void*);
-void hook_callback_file_control(Tox*, int32_t, uint8_t, uint8_t, uint8_t, uint8_t*, uint16_t, void*);
-void hook_callback_file_data(Tox*, int32_t, uint8_t, uint8_t*, uint16_t, void*);
-
-HOOK(callback_friend_request)
-HOOK(callback_friend_message)
-HOOK(callback_friend_action)
-HOOK(callback_name_change)
-HOOK(callback_status_message)
-HOOK(callback_user_status)
-HOOK(callback_typing_change)
-HOOK(callback_read_receipt)
-HOOK(callback_connection_status)
-HOOK(callback_file_send_request)
-HOOK(callback_file_control)
-HOOK(callback_file_data)
+// Creates the C function to directly register a given callback, and the
+// trampoline toxcore calls it through.
+// The userdata is a cgo.Handle, not a Go pointer: the trampoline gives it
+// to Go as an integer, which Go must never hold as a pointer.
+#define HOOK(x, params, args) \
+static void trampoline_##x params { \
+	hook_##x args; \
+} \
+static void set_##x(Tox *tox, uintptr_t handle) { \
+	tox_##x(tox, trampoline_##x, (void*)handle); \
+}
+
+void hook_callback_friend_request(Tox*, uint8_t*, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_friend_message(Tox*, int32_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_friend_action(Tox*, int32_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_name_change(Tox*, int32_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_status_message(Tox*, int32_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_user_status(Tox*, int32_t, uint8_t, uintptr_t);
+void hook_callback_typing_change(Tox*, int32_t, uint8_t, uintptr_t);
+void hook_callback_read_receipt(Tox*, int32_t, uint32_t, uintptr_t);
+void hook_callback_connection_status(Tox*, int32_t, uint8_t, uintptr_t);
+void hook_callback_file_send_request(Tox*, int32_t, uint8_t, uint64_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_file_control(Tox*, int32_t, uint8_t, uint8_t, uint8_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_file_data(Tox*, int32_t, uint8_t, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_group_invite(Tox*, int32_t, uint8_t*, uintptr_t);
+void hook_callback_group_message(Tox*, int, int, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_group_action(Tox*, int, int, uint8_t*, uint16_t, uintptr_t);
+void hook_callback_group_namelist_change(Tox*, int, int, uint8_t, uintptr_t);
+
+HOOK(callback_friend_request,
+	(Tox *tox, uint8_t *public_key, uint8_t *data, uint16_t length, void *userdata),
+	(tox, public_key, data, length, (uintptr_t)userdata))
+HOOK(callback_friend_message,
+	(Tox *tox, int32_t friendnumber, uint8_t *message, uint16_t length, void *userdata),
+	(tox, friendnumber, message, length, (uintptr_t)userdata))
+HOOK(callback_friend_action,
+	(Tox *tox, int32_t friendnumber, uint8_t *action, uint16_t length, void *userdata),
+	(tox, friendnumber, action, length, (uintptr_t)userdata))
+HOOK(callback_name_change,
+	(Tox *tox, int32_t friendnumber, uint8_t *name, uint16_t length, void *userdata),
+	(tox, friendnumber, name, length, (uintptr_t)userdata))
+HOOK(callback_status_message,
+	(Tox *tox, int32_t friendnumber, uint8_t *status, uint16_t length, void *userdata),
+	(tox, friendnumber, status, length, (uintptr_t)userdata))
+HOOK(callback_user_status,
+	(Tox *tox, int32_t friendnumber, uint8_t status, void *userdata),
+	(tox, friendnumber, status, (uintptr_t)userdata))
+HOOK(callback_typing_change,
+	(Tox *tox, int32_t friendnumber, uint8_t is_typing, void *userdata),
+	(tox, friendnumber, is_typing, (uintptr_t)userdata))
+HOOK(callback_read_receipt,
+	(Tox *tox, int32_t friendnumber, uint32_t receipt, void *userdata),
+	(tox, friendnumber, receipt, (uintptr_t)userdata))
+HOOK(callback_connection_status,
+	(Tox *tox, int32_t friendnumber, uint8_t status, void *userdata),
+	(tox, friendnumber, status, (uintptr_t)userdata))
+HOOK(callback_file_send_request,
+	(Tox *tox, int32_t friendnumber, uint8_t filenumber, uint64_t filesize, uint8_t *filename, uint16_t length, void *userdata),
+	(tox, friendnumber, filenumber, filesize, filename, length, (uintptr_t)userdata))
+HOOK(callback_file_control,
+	(Tox *tox, int32_t friendnumber, uint8_t send_receive, uint8_t filenumber, uint8_t control, uint8_t *data, uint16_t length, void *userdata),
+	(tox, friendnumber, send_receive, filenumber, control, data, length, (uintptr_t)userdata))
+HOOK(callback_file_data,
+	(Tox *tox, int32_t friendnumber, uint8_t filenumber, uint8_t *data, uint16_t length, void *userdata),
+	(tox, friendnumber, filenumber, data, length, (uintptr_t)userdata))
+HOOK(callback_group_invite,
+	(Tox *tox, int32_t friendnumber, uint8_t *group_public_key, void *userdata),
+	(tox, friendnumber, group_public_key, (uintptr_t)userdata))
+HOOK(callback_group_message,
+	(Tox *tox, int groupnumber, int peernumber, uint8_t *message, uint16_t length, void *userdata),
+	(tox, groupnumber, peernumber, message, length, (uintptr_t)userdata))
+HOOK(callback_group_action,
+	(Tox *tox, int groupnumber, int peernumber, uint8_t *action, uint16_t length, void *userdata),
+	(tox, groupnumber, peernumber, action, length, (uintptr_t)userdata))
+HOOK(callback_group_namelist_change,
+	(Tox *tox, int groupnumber, int peernumber, uint8_t change, void *userdata),
+	(tox, groupnumber, peernumber, change, (uintptr_t)userdata))
 
 */
 import "C"
 
 import (
-	"encoding/hex"
-	"errors"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/cgo"
 	"sync"
 	"time"
 	"unsafe"
 )
 
-type FriendRequestFunc func(publicKey []byte, data []byte, length uint16)
+type FriendRequestFunc func(publicKey PublicKey, data []byte, length uint16)
 type FriendMessageFunc func(friendNumber int32, message []byte, length uint16)
 type FriendActionFunc func(friendNumber int32, action []byte, length uint16)
 type NameChangeFunc func(friendNumber int32, newName []byte, length uint16)
@@ -64,173 +113,441 @@ type ConnectionStatusFunc func(friendNumber int32, status bool)
 type FileSendRequestFunc func(friendNumber int32, filenumber uint8, filesize uint64, filename []byte, filenameLength uint16)
 type FileControlFunc func(friendNumber int32, sending bool, filenumber uint8, fileControl FileControl, data []byte, length uint16)
 type FileDataFunc func(friendNumber int32, filenumber uint8, data []byte, length uint16)
+type GroupInviteFunc func(friendNumber int32, groupPublicKey []byte)
+type GroupMessageFunc func(groupNumber int, friendGroupNumber int, message []byte, length uint16)
+type GroupActionFunc func(groupNumber int, friendGroupNumber int, action []byte, length uint16)
+type GroupNamelistChangeFunc func(groupNumber int, peerNumber int, change ChatChange)
+type SelfConnectionStatusFunc func(connected bool, at time.Time)
+
+type SaveFunc func(data []byte) error
 
+// A Tox is safe for concurrent use by multiple goroutines. Callbacks and
+// events are delivered by Do once it has released its lock on the
+// instance, so they may call any method of the Tox they come from.
 type Tox struct {
-	tox *C.struct_Tox
-	mtx sync.Mutex
+	tox         *C.struct_Tox
+	handle      cgo.Handle
+	cleanup     runtime.Cleanup
+	mtx         sync.Mutex
+	ipv6Enabled bool
+	closed      bool
 	// Callbacks
-	friendRequestFunc    FriendRequestFunc
-	friendMessageFunc    FriendMessageFunc
-	friendActionFunc     FriendActionFunc
-	nameChangeFunc       NameChangeFunc
-	statusMessageFunc    StatusMessageFunc
-	userStatusFunc       UserStatusFunc
-	typingChangeFunc     TypingChangeFunc
-	readReceiptFunc      ReadReceiptFunc
-	connectionStatusFunc ConnectionStatusFunc
-	fileSendRequestFunc  FileSendRequestFunc
-	fileControlFunc      FileControlFunc
-	fileDataFunc         FileDataFunc
+	cbmtx     sync.Mutex
+	callbacks map[callbackKind][]*callback
+	// Callbacks queued by the hooks during tox_do, run by Do
+	pending []func()
+	// Event subscribers
+	evmtx       sync.Mutex
+	subscribers []*eventSubscriber
+	// File transfers
+	trmtx          sync.Mutex
+	transfers      map[transferKey]*Transfer
+	queued         map[int32][]*Transfer
+	finished       []*Transfer
+	sched          scheduler
+	acceptFileFunc AcceptFileFunc
+	statePath      string
+	partials       map[partialKey]PartialTransfer
+	sendDigests    bool
+	brokenTimeout  time.Duration
+	// Profile autosave
+	autosave *autosaver
+	// Closed if t is collected without being closed
+	collected chan struct{}
+	// DHT connection, checked by Do
+	conn ConnectionStats
+}
+
+// Options mirrors toxcore's Tox_Options. It has no port range: see
+// PORTRANGE_FROM.
+type Options struct {
+	IPv6Enabled bool
+	UDPDisabled bool
+	// Only SOCKS5 proxies are supported, and only with UDP disabled.
+	ProxyEnabled bool
+	ProxyAddress string
+	ProxyPort    uint16
 }
 
 func New() (*Tox, error) {
-	ctox := C.tox_new(ENABLE_IPV6_DEFAULT)
+	return NewWithOptions(Options{IPv6Enabled: ENABLE_IPV6_DEFAULT != 0})
+}
+
+func NewWithOptions(options Options) (*Tox, error) {
+	var coptions C.Tox_Options
+
+	coptions.ipv6enabled = cbool(options.IPv6Enabled)
+	coptions.udp_disabled = cbool(options.UDPDisabled)
+
+	if options.ProxyEnabled {
+		if !options.UDPDisabled {
+			return nil, fmt.Errorf("%w: proxy requires UDP to be disabled", ErrInvalidOptions)
+		}
+
+		if len(options.ProxyAddress) == 0 || len(options.ProxyAddress) >= len(coptions.proxy_address) {
+			return nil, fmt.Errorf("%w: invalid proxy address", ErrInvalidOptions)
+		}
+
+		if options.ProxyPort == 0 {
+			return nil, fmt.Errorf("%w: invalid proxy port", ErrInvalidOptions)
+		}
+
+		coptions.proxy_enabled = 1
+		for i := 0; i < len(options.ProxyAddress); i++ {
+			coptions.proxy_address[i] = (C.char)(options.ProxyAddress[i])
+		}
+		coptions.proxy_port = (C.uint16_t)(options.ProxyPort)
+	}
+
+	ctox := C.tox_new(&coptions)
 	if ctox == nil {
-		return nil, errors.New("Error initializing Tox")
+		return nil, ErrInit
 	}
 
-	t := &Tox{tox: ctox}
+	t := &Tox{
+		tox:           ctox,
+		ipv6Enabled:   options.IPv6Enabled,
+		transfers:     make(map[transferKey]*Transfer),
+		brokenTimeout: BROKEN_TRANSFER_TIMEOUT,
+		conn:          ConnectionStats{Started: time.Now()},
+		collected:     make(chan struct{}),
+	}
+	t.handle = register(t)
+	// Unlike a finalizer, runs even if callbacks or transfers stored in t
+	// point back to it
+	t.cleanup = runtime.AddCleanup(t, killUnreachable, unreachableTox{ctox, t.handle, t.collected})
 
 	return t, nil
 }
 
-func (t *Tox) Kill() {
+// unreachableTox is what is left to release of a Tox which was not closed
+// before becoming unreachable. It must not point to the Tox.
+type unreachableTox struct {
+	tox       *C.struct_Tox
+	handle    cgo.Handle
+	collected chan struct{}
+}
+
+// killUnreachable kills the toxcore instance of a Tox collected without
+// being closed, and stops its autosaver. Only Close saves the state for
+// SetAutosave and closes the event channels.
+func killUnreachable(u unreachableTox) {
+	C.tox_kill(u.tox)
+	u.handle.Delete()
+	close(u.collected)
+}
+
+// Close kills the toxcore instance, once Do is done with it, and removes
+// every callback and event subscription. Any later call to a method of t
+// returns ErrClosed. Close can be called several times.
+func (t *Tox) Close() error {
+	// Save the last changes while toxcore is still there
+	t.stopAutosave()
+
+	t.mtx.Lock()
+	if t.tox == nil {
+		t.mtx.Unlock()
+		return nil
+	}
+
 	C.tox_kill(t.tox)
+	t.handle.Delete()
+	t.cleanup.Stop()
+	t.tox = nil
+	t.closed = true
+	t.pending = nil
+	t.mtx.Unlock()
+
+	t.cbmtx.Lock()
+	t.callbacks = nil
+	t.cbmtx.Unlock()
+
+	t.evmtx.Lock()
+	subscribers := t.subscribers
+	t.subscribers = nil
+	t.evmtx.Unlock()
+
+	for _, s := range subscribers {
+		s.close()
+	}
+
+	t.closeTransfers()
+
+	return nil
+}
+
+// Kill is the same as Close.
+func (t *Tox) Kill() {
+	t.Close()
+}
+
+// nilError is returned by methods called with no toxcore instance.
+func (t *Tox) nilError() error {
+	if t.closed {
+		return ErrClosed
+	}
+	return ErrNotInitialized
 }
 
+// Do runs one iteration of toxcore, then calls the callbacks and
+// delivers the events it produced.
 func (t *Tox) Do() error {
+	t.mtx.Lock()
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		t.mtx.Unlock()
+		return t.nilError()
 	}
 
-	t.mtx.Lock()
 	C.tox_do(t.tox)
+	t.checkConnection(C.tox_isconnected(t.tox) == 1)
+
+	pending := t.pending
+	t.pending = nil
 	t.mtx.Unlock()
 
+	for _, f := range pending {
+		f()
+	}
+
+	t.startQueued()
+	t.expireBroken()
+	t.pumpTransfers()
+	t.notifyProgress()
+
 	return nil
 }
 
+func (t *Tox) DoInterval() (time.Duration, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return 0, t.nilError()
+	}
+
+	n := C.tox_do_interval(t.tox)
+
+	return time.Duration(n) * time.Millisecond, nil
+}
+
+// Run calls Do at the interval recommended by toxcore until ctx is done.
+// It then passes the result of Save to save, if not nil, and closes the
+// instance. The returned error is the one from Do, Save, save or Close.
+func (t *Tox) Run(ctx context.Context, save SaveFunc) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return t.shutdown(save)
+		case <-timer.C:
+			if err := t.Do(); err != nil {
+				return err
+			}
+
+			interval, err := t.DoInterval()
+			if err != nil {
+				return err
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+func (t *Tox) shutdown(save SaveFunc) error {
+	var err error
+
+	if save != nil {
+		var data []byte
+		if data, err = t.Save(); err == nil {
+			err = save(data)
+		}
+	}
+
+	if cerr := t.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
 func (t *Tox) BootstrapFromAddress(address string, port uint16, hexPublicKey string) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 
 	caddr := C.CString(address)
 	defer C.free(unsafe.Pointer(caddr))
 
-	pubkey, err := hex.DecodeString(hexPublicKey)
+	pubkey, err := ParsePublicKey(hexPublicKey)
 
 	if err != nil {
 		return err
 	}
 
-	C.tox_bootstrap_from_address(t.tox, caddr, ENABLE_IPV6_DEFAULT, C.htons((C.uint16_t)(port)), (*C.uint8_t)(&pubkey[0]))
+	ret := C.tox_bootstrap_from_address(t.tox, caddr, cbool(t.ipv6Enabled), C.htons((C.uint16_t)(port)), (*C.uint8_t)(&pubkey[0]))
+	if ret != 1 {
+		return ErrBootstrap
+	}
 
 	return nil
 }
 
 func (t *Tox) IsConnected() (bool, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return false, errors.New("Error getting address, tox not initialized")
+		return false, t.nilError()
 	}
 
 	return (C.tox_isconnected(t.tox) == 1), nil
 }
 
-func (t *Tox) GetAddress() ([]byte, error) {
+func (t *Tox) GetAddress() (ToxID, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var address ToxID
+
 	if t.tox == nil {
-		return nil, errors.New("Error getting address, tox not initialized")
+		return address, t.nilError()
 	}
 
-	address := make([]byte, FRIEND_ADDRESS_SIZE)
 	C.tox_get_address(t.tox, (*C.uint8_t)(&address[0]))
 
 	return address, nil
 }
 
-func (t *Tox) AddFriend(address []byte, data []byte) (FriendAddError, error) {
+func (t *Tox) AddFriend(address ToxID, data []byte) (int32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return FAERR_UNKNOWN, errors.New("Tox not initialized")
+		return -1, t.nilError()
 	}
 
-	if len(address) != FRIEND_ADDRESS_SIZE {
-		return FAERR_UNKNOWN, errors.New("Error adding friend, wrong size for address")
+	if !address.Valid() {
+		return -1, FAERR_BADCHECKSUM
 	}
 
-	faerr := C.tox_add_friend(t.tox, (*C.uint8_t)(&address[0]), (*C.uint8_t)(&data[0]), (C.uint16_t)(len(data)))
+	if len(data) == 0 {
+		return -1, FAERR_NOMESSAGE
+	}
+
+	n := C.tox_add_friend(t.tox, (*C.uint8_t)(&address[0]), (*C.uint8_t)(&data[0]), (C.uint16_t)(len(data)))
 
-	if faerr != 0 {
-		return FriendAddError(faerr), errors.New("Error adding friend")
+	if n < 0 {
+		return -1, FriendAddError(n)
 	}
+	t.changed()
 
-	return FriendAddError(faerr), nil
+	return int32(n), nil
 }
 
-func (t *Tox) AddFriendNorequest(clientId []byte) (int32, error) {
-	if t.tox == nil {
-		return -1, errors.New("Tox not initialized")
-	}
+func (t *Tox) AddFriendNorequest(clientId PublicKey) (int32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
 
-	if len(clientId) != CLIENT_ID_SIZE {
-		return -1, errors.New("Incorrect client id")
+	if t.tox == nil {
+		return -1, t.nilError()
 	}
 
 	n := C.tox_add_friend_norequest(t.tox, (*C.uint8_t)(&clientId[0]))
 	if n == -1 {
-		return -1, errors.New("Error adding friend")
-	}
+		// toxcore does not tell why, find out
+		var address ToxID
+		C.tox_get_address(t.tox, (*C.uint8_t)(&address[0]))
+		if address.PublicKey() == clientId {
+			return -1, FAERR_OWNKEY
+		}
+		if C.tox_get_friend_number(t.tox, (*C.uint8_t)(&clientId[0])) != -1 {
+			return -1, FAERR_ALREADYSENT
+		}
+		return -1, ErrAddFriend
+	}
+	t.changed()
 	return int32(n), nil
 }
 
-func (t *Tox) GetFriendNumber(clientId []byte) (int32, error) {
+func (t *Tox) GetFriendNumber(clientId PublicKey) (int32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("Tox not initialized")
+		return -1, t.nilError()
 	}
+
 	n := C.tox_get_friend_number(t.tox, (*C.uint8_t)(&clientId[0]))
+	if n == -1 {
+		return -1, ErrFriendNotFound
+	}
 
 	return int32(n), nil
 }
 
-func (t *Tox) GetClientId(friendNumber int32) ([]byte, error) {
+func (t *Tox) GetClientId(friendNumber int32) (PublicKey, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var clientId PublicKey
+
 	if t.tox == nil {
-		return nil, errors.New("Tox not initialized")
+		return clientId, t.nilError()
 	}
-	clientId := make([]byte, CLIENT_ID_SIZE)
 	ret := C.tox_get_client_id(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&clientId[0]))
 
 	if ret != 0 {
-		return nil, errors.New("Error retrieving client id")
+		return clientId, ErrFriendNotFound
 	}
 
 	return clientId, nil
 }
 
 func (t *Tox) DelFriend(friendNumber int32) error {
+	t.mtx.Lock()
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		t.mtx.Unlock()
+		return t.nilError()
 	}
 	ret := C.tox_del_friend(t.tox, (C.int32_t)(friendNumber))
 
 	if ret != 0 {
-		return errors.New("Error deleting friend")
+		t.mtx.Unlock()
+		return ErrFriendNotFound
 	}
+	t.changed()
+	t.mtx.Unlock()
+
+	// The transfers are gone with the friend
+	t.endFriendTransfers(friendNumber, ErrFriendNotFound)
 	return nil
 }
 
 func (t *Tox) GetFriendConnectionStatus(friendNumber int32) (bool, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return false, errors.New("Tox not initialized")
+		return false, t.nilError()
 	}
 	ret := C.tox_get_friend_connection_status(t.tox, (C.int32_t)(friendNumber))
 	if ret == -1 {
-		return false, errors.New("Error retrieving friend connection status")
+		return false, ErrFriendNotFound
 	}
 	return (int(ret) == 1), nil
 }
 
 func (t *Tox) FriendExists(friendNumber int32) (bool, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return false, errors.New("Tox not initialized")
+		return false, t.nilError()
 	}
 	//int tox_friend_exists(Tox *tox, int32_t friendnumber);
 	ret := C.tox_friend_exists(t.tox, (C.int32_t)(friendNumber))
@@ -239,75 +556,148 @@ func (t *Tox) FriendExists(friendNumber int32) (bool, error) {
 }
 
 func (t *Tox) SendMessage(friendNumber int32, message []byte) (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
+	}
+
+	if len(message) == 0 {
+		return 0, ErrEmptyMessage
+	}
+
+	if len(message) > MAX_MESSAGE_LENGTH {
+		return 0, ErrMessageTooLong
 	}
 
 	n := C.tox_send_message(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&message[0]), (C.uint32_t)(len(message)))
 	if n == 0 {
-		return 0, errors.New("Error sending message")
+		return 0, t.sendError(friendNumber)
 	}
 	return uint32(n), nil
 }
 
 func (t *Tox) SendMessageWithId(friendNumber int32, id uint32, message []byte) (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
+	}
+
+	if len(message) == 0 {
+		return 0, ErrEmptyMessage
+	}
+
+	if len(message) > MAX_MESSAGE_LENGTH {
+		return 0, ErrMessageTooLong
 	}
 
 	n := C.tox_send_message_withid(t.tox, (C.int32_t)(friendNumber), (C.uint32_t)(id), (*C.uint8_t)(&message[0]), (C.uint32_t)(len(message)))
 	if n == 0 {
-		return 0, errors.New("Error sending message")
+		return 0, t.sendError(friendNumber)
 	}
 	return uint32(n), nil
 }
 
 func (t *Tox) SendAction(friendNumber int32, action []byte) (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
+	}
+
+	if len(action) == 0 {
+		return 0, ErrEmptyMessage
+	}
+
+	if len(action) > MAX_MESSAGE_LENGTH {
+		return 0, ErrMessageTooLong
 	}
 
 	n := C.tox_send_action(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&action[0]), (C.uint32_t)(len(action)))
 	if n == 0 {
-		return 0, errors.New("Error sending action")
+		return 0, t.sendError(friendNumber)
 	}
 	return uint32(n), nil
 }
 
 func (t *Tox) SendActionWithId(friendNumber int32, id uint32, action []byte) (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
+	}
+
+	if len(action) == 0 {
+		return 0, ErrEmptyMessage
 	}
 
-	n := C.tox_send_message_withid(t.tox, (C.int32_t)(friendNumber), (C.uint32_t)(id), (*C.uint8_t)(&action[0]), (C.uint32_t)(len(action)))
+	if len(action) > MAX_MESSAGE_LENGTH {
+		return 0, ErrMessageTooLong
+	}
+
+	n := C.tox_send_action_withid(t.tox, (C.int32_t)(friendNumber), (C.uint32_t)(id), (*C.uint8_t)(&action[0]), (C.uint32_t)(len(action)))
 	if n == 0 {
-		return 0, errors.New("Error sending action")
+		return 0, t.sendError(friendNumber)
 	}
 	return uint32(n), nil
 }
 
+// sendError tells why toxcore failed to send to friendNumber.
+// t.mtx must be held.
+func (t *Tox) sendError(friendNumber int32) error {
+	if C.tox_friend_exists(t.tox, (C.int32_t)(friendNumber)) != 1 {
+		return ErrFriendNotFound
+	}
+
+	if C.tox_get_friend_connection_status(t.tox, (C.int32_t)(friendNumber)) != 1 {
+		return ErrFriendNotConnected
+	}
+
+	return ErrSendFailed
+}
+
 func (t *Tox) SetName(name string) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
+	}
+
+	if len(name) == 0 {
+		return ErrEmptyName
+	}
+
+	if len(name) > MAX_NAME_LENGTH {
+		return ErrNameTooLong
 	}
 
 	ret := C.tox_set_name(t.tox, (*C.uint8_t)(&[]byte(name)[0]), (C.uint16_t)(len(name)))
 	if ret != 0 {
-		return errors.New("Error setting name")
+		return ErrSetName
 	}
+	t.changed()
 	return nil
 }
 
 func (t *Tox) GetSelfName() (string, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return "", errors.New("Tox not initialized")
+		return "", t.nilError()
 	}
 
 	cname := make([]byte, MAX_NAME_LENGTH)
 
 	n := C.tox_get_self_name(t.tox, (*C.uint8_t)(&cname[0]))
 	if n == 0 {
-		return "", errors.New("Error retrieving self name")
+		return "", ErrSelfName
 	}
 
 	name := string(cname[:n])
@@ -316,15 +706,18 @@ func (t *Tox) GetSelfName() (string, error) {
 }
 
 func (t *Tox) GetName(friendNumber int32) (string, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return "", errors.New("Tox not initialized")
+		return "", t.nilError()
 	}
 
 	cname := make([]byte, MAX_NAME_LENGTH)
 
 	n := C.tox_get_name(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&cname[0]))
 	if n == -1 {
-		return "", errors.New("Error retrieving name")
+		return "", ErrFriendNotFound
 	}
 
 	name := string(cname[:n])
@@ -333,91 +726,120 @@ func (t *Tox) GetName(friendNumber int32) (string, error) {
 }
 
 func (t *Tox) GetNameSize(friendNumber int32) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("tox not initialized")
+		return -1, t.nilError()
 	}
 
 	ret := C.tox_get_name_size(t.tox, (C.int32_t)(friendNumber))
 	if ret == -1 {
-		return -1, errors.New("Error retrieving name size")
+		return -1, ErrFriendNotFound
 	}
 
 	return int(ret), nil
 }
 
 func (t *Tox) GetSelfNameSize() (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("tox not initialized")
+		return -1, t.nilError()
 	}
 
 	ret := C.tox_get_self_name_size(t.tox)
 	if ret == -1 {
-		return -1, errors.New("Error retrieving self name size")
+		return -1, ErrSelfName
 	}
 
 	return int(ret), nil
 }
 
 func (t *Tox) SetStatusMessage(status []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
+	}
+
+	if len(status) > MAX_STATUSMESSAGE_LENGTH {
+		return ErrStatusMessageTooLong
 	}
 
-	ret := C.tox_set_status_message(t.tox, (*C.uint8_t)(&status[0]), (C.uint16_t)(len(status)))
+	// toxcore accepts an empty status message, but cgo needs an element
+	// to point to
+	cstatus := append(status[:len(status):len(status)], 0)
+
+	ret := C.tox_set_status_message(t.tox, (*C.uint8_t)(&cstatus[0]), (C.uint16_t)(len(status)))
 	if ret != 0 {
-		return errors.New("Error setting status message")
+		return ErrSetStatusMessage
 	}
 	return nil
 }
 
 func (t *Tox) SetUserStatus(status UserStatus) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 
 	ret := C.tox_set_user_status(t.tox, (C.uint8_t)(status))
 	if ret != 0 {
-		return errors.New("Error setting status")
+		return ErrInvalidUserStatus
 	}
 	return nil
 }
 
 func (t *Tox) GetStatusMessageSize(friendNumber int32) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("tox not initialized")
+		return -1, t.nilError()
 	}
 
 	ret := C.tox_get_status_message_size(t.tox, (C.int32_t)(friendNumber))
 	if ret == -1 {
-		return -1, errors.New("Error retrieving status message size")
+		return -1, ErrFriendNotFound
 	}
 
 	return int(ret), nil
 }
 
 func (t *Tox) GetSelfStatusMessageSize() (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("tox not initialized")
+		return -1, t.nilError()
 	}
 
 	ret := C.tox_get_self_status_message_size(t.tox)
 	if ret == -1 {
-		return -1, errors.New("Error retrieving self status message size")
+		return -1, ErrSelfStatusMessage
 	}
 
 	return int(ret), nil
 }
 
 func (t *Tox) GetStatusMessage(friendNumber int32) ([]byte, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return nil, errors.New("Tox not initialized")
+		return nil, t.nilError()
 	}
 
 	status := make([]byte, MAX_STATUSMESSAGE_LENGTH)
 
 	n := C.tox_get_status_message(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&status[0]), MAX_STATUSMESSAGE_LENGTH)
 	if n == -1 {
-		return nil, errors.New("Error retrieving status message")
+		return nil, ErrFriendNotFound
 	}
 
 	// Truncate status to n-byte read
@@ -427,15 +849,18 @@ func (t *Tox) GetStatusMessage(friendNumber int32) ([]byte, error) {
 }
 
 func (t *Tox) GetSelfStatusMessage() ([]byte, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return nil, errors.New("Tox not initialized")
+		return nil, t.nilError()
 	}
 
 	status := make([]byte, MAX_STATUSMESSAGE_LENGTH)
 
 	n := C.tox_get_self_status_message(t.tox, (*C.uint8_t)(&status[0]), MAX_STATUSMESSAGE_LENGTH)
 	if n == -1 {
-		return nil, errors.New("Error retrieving self status message")
+		return nil, ErrSelfStatusMessage
 	}
 
 	// Truncate status to n-byte read
@@ -445,8 +870,11 @@ func (t *Tox) GetSelfStatusMessage() ([]byte, error) {
 }
 
 func (t *Tox) GetUserStatus(friendNumber int32) (UserStatus, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return USERSTATUS_INVALID, errors.New("Tox not initialized")
+		return USERSTATUS_INVALID, t.nilError()
 	}
 	n := C.tox_get_user_status(t.tox, (C.int32_t)(friendNumber))
 
@@ -454,8 +882,11 @@ func (t *Tox) GetUserStatus(friendNumber int32) (UserStatus, error) {
 }
 
 func (t *Tox) GetSelfUserStatus() (UserStatus, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return USERSTATUS_INVALID, errors.New("Tox not initialized")
+		return USERSTATUS_INVALID, t.nilError()
 	}
 	n := C.tox_get_self_user_status(t.tox)
 
@@ -463,13 +894,16 @@ func (t *Tox) GetSelfUserStatus() (UserStatus, error) {
 }
 
 func (t *Tox) GetLastOnline(friendNumber int32) (time.Time, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return time.Time{}, errors.New("Tox not initialized")
+		return time.Time{}, t.nilError()
 	}
 	ret := C.tox_get_last_online(t.tox, (C.int32_t)(friendNumber))
 
 	if int(ret) == -1 {
-		return time.Time{}, errors.New("Error getting last online time")
+		return time.Time{}, ErrFriendNotFound
 	}
 
 	last := time.Unix(int64(ret), 0)
@@ -478,8 +912,11 @@ func (t *Tox) GetLastOnline(friendNumber int32) (time.Time, error) {
 }
 
 func (t *Tox) SetUserIsTyping(friendNumber int32, isTyping bool) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 	typing := 0
 	if isTyping {
@@ -489,15 +926,18 @@ func (t *Tox) SetUserIsTyping(friendNumber int32, isTyping bool) error {
 	ret := C.tox_set_user_is_typing(t.tox, (C.int32_t)(friendNumber), (C.uint8_t)(typing))
 
 	if ret != 0 {
-		return errors.New("Error setting typing status")
+		return ErrFriendNotFound
 	}
 
 	return nil
 }
 
 func (t *Tox) GetIsTyping(friendNumber int32) (bool, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return false, errors.New("Tox not initialized")
+		return false, t.nilError()
 	}
 
 	ret := C.tox_get_is_typing(t.tox, (C.int32_t)(friendNumber))
@@ -506,8 +946,11 @@ func (t *Tox) GetIsTyping(friendNumber int32) (bool, error) {
 }
 
 func (t *Tox) SetSendsReceipts(friendNumber int32, send bool) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 	csend := 0
 	if send {
@@ -520,8 +963,11 @@ func (t *Tox) SetSendsReceipts(friendNumber int32, send bool) error {
 }
 
 func (t *Tox) CountFriendlist() (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
 	}
 	n := C.tox_count_friendlist(t.tox)
 
@@ -529,8 +975,11 @@ func (t *Tox) CountFriendlist() (uint32, error) {
 }
 
 func (t *Tox) GetNumOnlineFriends() (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
 	}
 	n := C.tox_get_num_online_friends(t.tox)
 
@@ -538,11 +987,17 @@ func (t *Tox) GetNumOnlineFriends() (uint32, error) {
 }
 
 func (t *Tox) GetFriendlist() ([]int32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return nil, errors.New("Tox not initialized")
+		return nil, t.nilError()
 	}
 
-	size, _ := t.CountFriendlist()
+	size := C.tox_count_friendlist(t.tox)
+	if size == 0 {
+		return []int32{}, nil
+	}
 	cfriendlist := make([]int32, size)
 
 	n := C.tox_get_friendlist(t.tox, (*C.int32_t)(&cfriendlist[0]), (C.uint32_t)(size))
@@ -553,8 +1008,11 @@ func (t *Tox) GetFriendlist() ([]int32, error) {
 }
 
 func (t *Tox) GetNospam() (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
 	}
 
 	n := C.tox_get_nospam(t.tox)
@@ -563,36 +1021,49 @@ func (t *Tox) GetNospam() (uint32, error) {
 }
 
 func (t *Tox) SetNospam(nospam uint32) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 
 	C.tox_set_nospam(t.tox, (C.uint32_t)(nospam))
+	t.changed()
 
 	return nil
 }
 
 func (t *Tox) NewFileSender(friendNumber int32, filesize uint64, filename []byte) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("Tox not initialized")
+		return -1, t.nilError()
 	}
 
 	if len(filename) > 255 {
-		return -1, errors.New("Filename too long")
+		return -1, ErrFilenameTooLong
 	}
 
 	n := C.tox_new_file_sender(t.tox, (C.int32_t)(friendNumber), (C.uint64_t)(filesize), (*C.uint8_t)(&filename[0]), (C.uint16_t)(len(filename)))
 
 	if n == -1 {
-		return -1, errors.New("Error sending file request")
+		if err := t.sendError(friendNumber); err != ErrSendFailed {
+			return -1, err
+		}
+		return -1, ErrNoFileSlot
 	}
 
 	return int(n), nil
 }
 
 func (t *Tox) FileSendControl(friendNumber int32, receiving bool, filenumber uint8, messageId FileControl, data []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 
 	cReceiving := 0
@@ -616,48 +1087,62 @@ func (t *Tox) FileSendControl(friendNumber int32, receiving bool, filenumber uin
 	n := C.tox_file_send_control(t.tox, (C.int32_t)(friendNumber), (C.uint8_t)(cReceiving), (C.uint8_t)(filenumber), (C.uint8_t)(messageId), cdata, clen)
 
 	if n == -1 {
-		return errors.New("Error sending file control")
+		if err := t.sendError(friendNumber); err != ErrSendFailed {
+			return err
+		}
+		return ErrFileControl
 	}
 
 	return nil
 }
 
 func (t *Tox) FileSendData(friendNumber int32, filenumber uint8, data []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("Tox not initialized")
+		return t.nilError()
 	}
 
 	if len(data) == 0 {
-		return errors.New("Error sending empty data")
-
+		return ErrEmptyData
 	}
 
 	n := C.tox_file_send_data(t.tox, (C.int32_t)(friendNumber), (C.uint8_t)(filenumber), (*C.uint8_t)(&data[0]), (C.uint16_t)(len(data)))
 
 	if n == -1 {
-		return errors.New("Error sending file data, is data too big ?")
+		if err := t.sendError(friendNumber); err != ErrSendFailed {
+			return err
+		}
+		return ErrFileSendData
 	}
 
 	return nil
 }
 
 func (t *Tox) FileDataSize(friendNumber int32) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return -1, errors.New("Tox not initialized")
+		return -1, t.nilError()
 	}
 
 	n := C.tox_file_data_size(t.tox, (C.int32_t)(friendNumber))
 
 	if n == -1 {
-		return -1, errors.New("Error getting file data size")
+		return -1, ErrFriendNotFound
 	}
 
 	return int(n), nil
 }
 
 func (t *Tox) FileDataRemaining(friendNumber int32, filenumber uint8, receiving bool) (uint64, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("Tox not initialized")
+		return 0, t.nilError()
 	}
 
 	cReceiving := 0
@@ -668,25 +1153,183 @@ func (t *Tox) FileDataRemaining(friendNumber int32, filenumber uint8, receiving
 	n := C.tox_file_data_remaining(t.tox, (C.int32_t)(friendNumber), (C.uint8_t)(filenumber), (C.uint8_t)(cReceiving))
 
 	if n == 0 {
-		return 0, errors.New("Error sending file control")
+		return 0, ErrFileNotFound
 	}
 
 	return uint64(n), nil
 }
 
+func (t *Tox) AddGroupchat() (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return -1, t.nilError()
+	}
+
+	n := C.tox_add_groupchat(t.tox)
+	if n == -1 {
+		return -1, ErrAddGroupchat
+	}
+
+	return int(n), nil
+}
+
+func (t *Tox) DelGroupchat(groupNumber int) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
+	}
+
+	ret := C.tox_del_groupchat(t.tox, (C.int)(groupNumber))
+	if ret != 0 {
+		return ErrGroupNotFound
+	}
+
+	return nil
+}
+
+func (t *Tox) GroupPeername(groupNumber int, peerNumber int) (string, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return "", t.nilError()
+	}
+
+	cname := make([]byte, MAX_NAME_LENGTH)
+
+	n := C.tox_group_peername(t.tox, (C.int)(groupNumber), (C.int)(peerNumber), (*C.uint8_t)(&cname[0]))
+	if n == -1 {
+		return "", ErrPeerNotFound
+	}
+
+	name := string(cname[:n])
+
+	return name, nil
+}
+
+func (t *Tox) InviteFriend(friendNumber int32, groupNumber int) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
+	}
+
+	ret := C.tox_invite_friend(t.tox, (C.int32_t)(friendNumber), (C.int)(groupNumber))
+	if ret != 0 {
+		return ErrInviteFriend
+	}
+
+	return nil
+}
+
+func (t *Tox) JoinGroupchat(friendNumber int32, friendGroupPublicKey []byte) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return -1, t.nilError()
+	}
+
+	if len(friendGroupPublicKey) != CLIENT_ID_SIZE {
+		return -1, ErrInvalidGroupKey
+	}
+
+	n := C.tox_join_groupchat(t.tox, (C.int32_t)(friendNumber), (*C.uint8_t)(&friendGroupPublicKey[0]))
+	if n == -1 {
+		return -1, ErrJoinGroupchat
+	}
+
+	return int(n), nil
+}
+
+func (t *Tox) GroupMessageSend(groupNumber int, message []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
+	}
+
+	if len(message) == 0 {
+		return ErrEmptyMessage
+	}
+
+	if len(message) > MAX_MESSAGE_LENGTH {
+		return ErrMessageTooLong
+	}
+
+	ret := C.tox_group_message_send(t.tox, (C.int)(groupNumber), (*C.uint8_t)(&message[0]), (C.uint32_t)(len(message)))
+	if ret != 0 {
+		return ErrGroupSendFailed
+	}
+
+	return nil
+}
+
+func (t *Tox) GroupActionSend(groupNumber int, action []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
+	}
+
+	if len(action) == 0 {
+		return ErrEmptyMessage
+	}
+
+	if len(action) > MAX_MESSAGE_LENGTH {
+		return ErrMessageTooLong
+	}
+
+	ret := C.tox_group_action_send(t.tox, (C.int)(groupNumber), (*C.uint8_t)(&action[0]), (C.uint32_t)(len(action)))
+	if ret != 0 {
+		return ErrGroupSendFailed
+	}
+
+	return nil
+}
+
+func (t *Tox) GroupNumberPeers(groupNumber int) (int, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return -1, t.nilError()
+	}
+
+	n := C.tox_group_number_peers(t.tox, (C.int)(groupNumber))
+	if n == -1 {
+		return -1, ErrGroupNotFound
+	}
+
+	return int(n), nil
+}
+
 func (t *Tox) Size() (uint32, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return 0, errors.New("tox not initialized")
+		return 0, t.nilError()
 	}
 
 	return uint32(C.tox_size(t.tox)), nil
 }
 
 func (t *Tox) Save() ([]byte, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return nil, errors.New("tox not initialized")
+		return nil, t.nilError()
 	}
-	size, _ := t.Size()
+	size := C.tox_size(t.tox)
 
 	data := make([]byte, size)
 	C.tox_save(t.tox, (*C.uint8_t)(&data[0]))
@@ -696,98 +1339,244 @@ func (t *Tox) Save() ([]byte, error) {
 }
 
 func (t *Tox) Load(data []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
 	if t.tox == nil {
-		return errors.New("tox not initialized")
+		return t.nilError()
+	}
+
+	if len(data) == 0 {
+		return ErrEmptyData
 	}
 
 	ret := C.tox_load(t.tox, (*C.uint8_t)(&data[0]), (C.uint32_t)(len(data)))
 
 	if ret == -1 {
-		return errors.New("Error loading data")
+		return ErrLoad
 	}
 	return nil
 }
 
-func (t *Tox) CallbackFriendRequest(f FriendRequestFunc) {
-	if t.tox != nil {
-		t.friendRequestFunc = f
-		C.set_callback_friend_request(t.tox, unsafe.Pointer(t))
+// The Callback* methods add f to the funcs called for an event, which are
+// called in registration order. They return a function removing f.
+func (t *Tox) CallbackFriendRequest(f FriendRequestFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_friend_request(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFriendRequest, f)
 }
 
-func (t *Tox) CallbackFriendMessage(f FriendMessageFunc) {
-	if t.tox != nil {
-		t.friendMessageFunc = f
-		C.set_callback_friend_message(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackFriendMessage(f FriendMessageFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_friend_message(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFriendMessage, f)
 }
 
-func (t *Tox) CallbackFriendAction(f FriendActionFunc) {
-	if t.tox != nil {
-		t.friendActionFunc = f
-		C.set_callback_friend_action(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackFriendAction(f FriendActionFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_friend_action(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFriendAction, f)
 }
 
-func (t *Tox) CallbackNameChange(f NameChangeFunc) {
-	if t.tox != nil {
-		t.nameChangeFunc = f
-		C.set_callback_name_change(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackNameChange(f NameChangeFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_name_change(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbNameChange, f)
 }
 
-func (t *Tox) CallbackStatusMessage(f StatusMessageFunc) {
-	if t.tox != nil {
-		t.statusMessageFunc = f
-		C.set_callback_status_message(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackStatusMessage(f StatusMessageFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_status_message(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbStatusMessage, f)
 }
 
-func (t *Tox) CallbackUserStatus(f UserStatusFunc) {
-	if t.tox != nil {
-		t.userStatusFunc = f
-		C.set_callback_user_status(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackUserStatus(f UserStatusFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_user_status(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbUserStatus, f)
 }
 
-func (t *Tox) CallbackTypingChange(f TypingChangeFunc) {
-	if t.tox != nil {
-		t.typingChangeFunc = f
-		C.set_callback_typing_change(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackTypingChange(f TypingChangeFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_typing_change(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbTypingChange, f)
 }
 
-func (t *Tox) CallbackReadReceipt(f ReadReceiptFunc) {
-	if t.tox != nil {
-		t.readReceiptFunc = f
-		C.set_callback_read_receipt(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackReadReceipt(f ReadReceiptFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_read_receipt(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbReadReceipt, f)
+}
+
+func (t *Tox) CallbackConnectionStatus(f ConnectionStatusFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_connection_status(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbConnectionStatus, f)
+}
+
+func (t *Tox) CallbackFileSendRequest(f FileSendRequestFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_file_send_request(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFileSendRequest, f)
+}
+
+func (t *Tox) CallbackFileControl(f FileControlFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_file_control(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFileControl, f)
+}
+
+func (t *Tox) CallbackFileData(f FileDataFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_file_data(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbFileData, f)
 }
 
-func (t *Tox) CallbackConnectionStatus(f ConnectionStatusFunc) {
-	if t.tox != nil {
-		t.connectionStatusFunc = f
-		C.set_callback_connection_status(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackGroupInvite(f GroupInviteFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_group_invite(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbGroupInvite, f)
+}
+
+func (t *Tox) CallbackGroupMessage(f GroupMessageFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
+	}
+	C.set_callback_group_message(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbGroupMessage, f)
+}
+
+func (t *Tox) CallbackGroupAction(f GroupActionFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_group_action(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbGroupAction, f)
 }
 
-func (t *Tox) CallbackFileSendRequest(f FileSendRequestFunc) {
-	if t.tox != nil {
-		t.fileSendRequestFunc = f
-		C.set_callback_file_send_request(t.tox, unsafe.Pointer(t))
+func (t *Tox) CallbackGroupNamelistChange(f GroupNamelistChangeFunc) func() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil || f == nil {
+		return func() {}
 	}
+	C.set_callback_group_namelist_change(t.tox, (C.uintptr_t)(t.handle))
+	return t.addCallback(cbGroupNamelistChange, f)
 }
 
-func (t *Tox) CallbackFileControl(f FileControlFunc) {
-	if t.tox != nil {
-		t.fileControlFunc = f
-		C.set_callback_file_control(t.tox, unsafe.Pointer(t))
+func cbool(b bool) C.uint8_t {
+	if b {
+		return 1
 	}
+	return 0
 }
 
-func (t *Tox) CallbackFileData(f FileDataFunc) {
-	if t.tox != nil {
-		t.fileDataFunc = f
-		C.set_callback_file_data(t.tox, unsafe.Pointer(t))
+// setHooks registers every hook at once, so that events are delivered
+// even for callbacks that were never set. t.mtx must be held.
+func (t *Tox) setHooks() {
+	C.set_callback_friend_request(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_friend_message(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_friend_action(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_name_change(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_status_message(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_user_status(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_typing_change(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_read_receipt(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_connection_status(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_file_send_request(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_file_control(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_file_data(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_group_invite(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_group_message(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_group_action(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_group_namelist_change(t.tox, (C.uintptr_t)(t.handle))
+}
+
+// setFileHooks registers the hooks needed by the transfers.
+func (t *Tox) setFileHooks() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.tox == nil {
+		return t.nilError()
 	}
+
+	C.set_callback_file_send_request(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_file_control(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_file_data(t.tox, (C.uintptr_t)(t.handle))
+	C.set_callback_connection_status(t.tox, (C.uintptr_t)(t.handle))
+
+	return nil
 }
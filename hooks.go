@@ -6,76 +6,266 @@ package golibtox
 #include <tox/tox.h>
 */
 import "C"
-import "unsafe"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// The hooks are called by toxcore from within tox_do, while Do holds the
+// lock on the Tox. They only queue the work, Do runs it after unlocking.
+func (t *Tox) queue(f func()) {
+	t.pending = append(t.pending, f)
+}
 
 //export hook_callback_friend_request
-func hook_callback_friend_request(t unsafe.Pointer, publicKey *C.uint8_t, data *C.uint8_t, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).friendRequestFunc(C.GoBytes((unsafe.Pointer)(publicKey), FRIEND_ADDRESS_SIZE), C.GoBytes((unsafe.Pointer)(data), (C.int)(length)), uint16(length))
+func hook_callback_friend_request(t unsafe.Pointer, publicKey *C.uint8_t, data *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	var goPublicKey PublicKey
+	copy(goPublicKey[:], C.GoBytes((unsafe.Pointer)(publicKey), CLIENT_ID_SIZE))
+	goData := C.GoBytes((unsafe.Pointer)(data), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbFriendRequest) {
+			f.(FriendRequestFunc)(goPublicKey, goData, uint16(length))
+		}
+		gtox.emit(FriendRequestEvent{goPublicKey, goData})
+	})
 }
 
 //export hook_callback_friend_message
-func hook_callback_friend_message(t unsafe.Pointer, friendNumber C.int32_t, message *C.uint8_t, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).friendMessageFunc(int32(friendNumber), C.GoBytes((unsafe.Pointer)(message), (C.int)(length)), uint16(length))
+func hook_callback_friend_message(t unsafe.Pointer, friendNumber C.int32_t, message *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goMessage := C.GoBytes((unsafe.Pointer)(message), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbFriendMessage) {
+			f.(FriendMessageFunc)(int32(friendNumber), goMessage, uint16(length))
+		}
+		gtox.emit(FriendMessageEvent{int32(friendNumber), goMessage})
+	})
 }
 
 //export hook_callback_friend_action
-func hook_callback_friend_action(t unsafe.Pointer, friendNumber C.int32_t, action *C.uint8_t, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).friendActionFunc(int32(friendNumber), C.GoBytes((unsafe.Pointer)(action), (C.int)(length)), uint16(length))
+func hook_callback_friend_action(t unsafe.Pointer, friendNumber C.int32_t, action *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goAction := C.GoBytes((unsafe.Pointer)(action), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbFriendAction) {
+			f.(FriendActionFunc)(int32(friendNumber), goAction, uint16(length))
+		}
+		gtox.emit(FriendActionEvent{int32(friendNumber), goAction})
+	})
 }
 
 //export hook_callback_name_change
-func hook_callback_name_change(t unsafe.Pointer, friendNumber C.int32_t, newName *C.uint8_t, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).nameChangeFunc(int32(friendNumber), C.GoBytes((unsafe.Pointer)(newName), (C.int)(length)), uint16(length))
+func hook_callback_name_change(t unsafe.Pointer, friendNumber C.int32_t, newName *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goNewName := C.GoBytes((unsafe.Pointer)(newName), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbNameChange) {
+			f.(NameChangeFunc)(int32(friendNumber), goNewName, uint16(length))
+		}
+		gtox.emit(NameChangeEvent{int32(friendNumber), goNewName})
+	})
 }
 
 //export hook_callback_status_message
-func hook_callback_status_message(t unsafe.Pointer, friendNumber C.int32_t, newStatus *C.uint8_t, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).statusMessageFunc(int32(friendNumber), C.GoBytes((unsafe.Pointer)(newStatus), (C.int)(length)), uint16(length))
+func hook_callback_status_message(t unsafe.Pointer, friendNumber C.int32_t, newStatus *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goNewStatus := C.GoBytes((unsafe.Pointer)(newStatus), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbStatusMessage) {
+			f.(StatusMessageFunc)(int32(friendNumber), goNewStatus, uint16(length))
+		}
+		gtox.emit(StatusMessageEvent{int32(friendNumber), goNewStatus})
+	})
 }
 
 //export hook_callback_user_status
-func hook_callback_user_status(t unsafe.Pointer, friendNumber C.int32_t, status C.uint8_t, tox unsafe.Pointer) {
-	(*Tox)(tox).userStatusFunc(int32(friendNumber), UserStatus(status))
+func hook_callback_user_status(t unsafe.Pointer, friendNumber C.int32_t, status C.uint8_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbUserStatus) {
+			f.(UserStatusFunc)(int32(friendNumber), UserStatus(status))
+		}
+		gtox.emit(UserStatusEvent{int32(friendNumber), UserStatus(status)})
+	})
 }
 
 //export hook_callback_typing_change
-func hook_callback_typing_change(t unsafe.Pointer, friendNumber C.int32_t, isTyping C.uint8_t, tox unsafe.Pointer) {
+func hook_callback_typing_change(t unsafe.Pointer, friendNumber C.int32_t, isTyping C.uint8_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
 	typing := false
 	if isTyping == 1 {
 		typing = true
 	}
-	(*Tox)(tox).typingChangeFunc(int32(friendNumber), typing)
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbTypingChange) {
+			f.(TypingChangeFunc)(int32(friendNumber), typing)
+		}
+		gtox.emit(TypingChangeEvent{int32(friendNumber), typing})
+	})
 }
 
 //export hook_callback_read_receipt
-func hook_callback_read_receipt(t unsafe.Pointer, friendNumber C.int32_t, receipt C.uint32_t, tox unsafe.Pointer) {
-	(*Tox)(tox).readReceiptFunc(int32(friendNumber), uint32(receipt))
+func hook_callback_read_receipt(t unsafe.Pointer, friendNumber C.int32_t, receipt C.uint32_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbReadReceipt) {
+			f.(ReadReceiptFunc)(int32(friendNumber), uint32(receipt))
+		}
+		gtox.emit(ReadReceiptEvent{int32(friendNumber), uint32(receipt)})
+	})
 }
 
 //export hook_callback_connection_status
-func hook_callback_connection_status(t unsafe.Pointer, friendNumber C.int32_t, status C.uint8_t, tox unsafe.Pointer) {
+func hook_callback_connection_status(t unsafe.Pointer, friendNumber C.int32_t, status C.uint8_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
 	goStatus := false
 	if status == 1 {
 		goStatus = true
 	}
-	(*Tox)(tox).connectionStatusFunc(int32(friendNumber), goStatus)
+	gtox.queue(func() {
+		gtox.handleConnectionStatus(int32(friendNumber), goStatus)
+		for _, f := range gtox.handlers(cbConnectionStatus) {
+			f.(ConnectionStatusFunc)(int32(friendNumber), goStatus)
+		}
+		gtox.emit(ConnectionStatusEvent{int32(friendNumber), goStatus})
+	})
 }
 
 //export hook_callback_file_send_request
-func hook_callback_file_send_request(t unsafe.Pointer, friendNumber C.int32_t, filenumber C.uint8_t, filesize C.uint64_t, filename unsafe.Pointer, filenameLength C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).fileSendRequestFunc(int32(friendNumber), uint8(filenumber), uint64(filesize), C.GoBytes(unsafe.Pointer(filename), C.int(filenameLength)), uint16(filenameLength))
+func hook_callback_file_send_request(t unsafe.Pointer, friendNumber C.int32_t, filenumber C.uint8_t, filesize C.uint64_t, filename unsafe.Pointer, filenameLength C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goFilename := C.GoBytes(unsafe.Pointer(filename), C.int(filenameLength))
+	gtox.queue(func() {
+		gtox.handleFileSendRequest(int32(friendNumber), uint8(filenumber), uint64(filesize), goFilename)
+		for _, f := range gtox.handlers(cbFileSendRequest) {
+			f.(FileSendRequestFunc)(int32(friendNumber), uint8(filenumber), uint64(filesize), goFilename, uint16(filenameLength))
+		}
+		gtox.emit(FileSendRequestEvent{int32(friendNumber), uint8(filenumber), uint64(filesize), goFilename})
+	})
 }
 
 //export hook_callback_file_control
-func hook_callback_file_control(t unsafe.Pointer, friendNumber C.int32_t, sending C.uint8_t, filenumber C.uint8_t, fileControl C.uint8_t, data unsafe.Pointer, length C.uint16_t, tox unsafe.Pointer) {
+func hook_callback_file_control(t unsafe.Pointer, friendNumber C.int32_t, sending C.uint8_t, filenumber C.uint8_t, fileControl C.uint8_t, data unsafe.Pointer, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
 	goSending := false
 	if sending == 1 {
 		goSending = true
 	}
-	(*Tox)(tox).fileControlFunc(int32(friendNumber), goSending, uint8(filenumber), FileControl(fileControl), C.GoBytes(unsafe.Pointer(data), C.int(length)), uint16(length))
+	goData := C.GoBytes(unsafe.Pointer(data), C.int(length))
+	gtox.queue(func() {
+		gtox.handleFileControl(int32(friendNumber), goSending, uint8(filenumber), FileControl(fileControl), goData)
+		for _, f := range gtox.handlers(cbFileControl) {
+			f.(FileControlFunc)(int32(friendNumber), goSending, uint8(filenumber), FileControl(fileControl), goData, uint16(length))
+		}
+		gtox.emit(FileControlEvent{int32(friendNumber), goSending, uint8(filenumber), FileControl(fileControl), goData})
+	})
 }
 
 //export hook_callback_file_data
-func hook_callback_file_data(t unsafe.Pointer, friendNumber C.int32_t, filenumber C.uint8_t, data unsafe.Pointer, length C.uint16_t, tox unsafe.Pointer) {
-	(*Tox)(tox).fileDataFunc(int32(friendNumber), uint8(filenumber), C.GoBytes(unsafe.Pointer(data), C.int(length)), uint16(length))
+func hook_callback_file_data(t unsafe.Pointer, friendNumber C.int32_t, filenumber C.uint8_t, data unsafe.Pointer, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goData := C.GoBytes(unsafe.Pointer(data), C.int(length))
+	gtox.queue(func() {
+		gtox.handleFileData(int32(friendNumber), uint8(filenumber), goData)
+		for _, f := range gtox.handlers(cbFileData) {
+			f.(FileDataFunc)(int32(friendNumber), uint8(filenumber), goData, uint16(length))
+		}
+		gtox.emit(FileDataEvent{int32(friendNumber), uint8(filenumber), goData})
+	})
+}
+
+//export hook_callback_group_invite
+func hook_callback_group_invite(t unsafe.Pointer, friendNumber C.int32_t, groupPublicKey *C.uint8_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goGroupPublicKey := C.GoBytes((unsafe.Pointer)(groupPublicKey), CLIENT_ID_SIZE)
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbGroupInvite) {
+			f.(GroupInviteFunc)(int32(friendNumber), goGroupPublicKey)
+		}
+		gtox.emit(GroupInviteEvent{int32(friendNumber), goGroupPublicKey})
+	})
+}
+
+//export hook_callback_group_message
+func hook_callback_group_message(t unsafe.Pointer, groupNumber C.int, friendGroupNumber C.int, message *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goMessage := C.GoBytes((unsafe.Pointer)(message), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbGroupMessage) {
+			f.(GroupMessageFunc)(int(groupNumber), int(friendGroupNumber), goMessage, uint16(length))
+		}
+		gtox.emit(GroupMessageEvent{int(groupNumber), int(friendGroupNumber), goMessage})
+	})
+}
+
+//export hook_callback_group_action
+func hook_callback_group_action(t unsafe.Pointer, groupNumber C.int, friendGroupNumber C.int, action *C.uint8_t, length C.uint16_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	goAction := C.GoBytes((unsafe.Pointer)(action), (C.int)(length))
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbGroupAction) {
+			f.(GroupActionFunc)(int(groupNumber), int(friendGroupNumber), goAction, uint16(length))
+		}
+		gtox.emit(GroupActionEvent{int(groupNumber), int(friendGroupNumber), goAction})
+	})
+}
+
+//export hook_callback_group_namelist_change
+func hook_callback_group_namelist_change(t unsafe.Pointer, groupNumber C.int, peerNumber C.int, change C.uint8_t, userdata C.uintptr_t) {
+	gtox := lookup(cgo.Handle(userdata))
+	if gtox == nil {
+		return
+	}
+	gtox.queue(func() {
+		for _, f := range gtox.handlers(cbGroupNamelistChange) {
+			f.(GroupNamelistChangeFunc)(int(groupNumber), int(peerNumber), ChatChange(change))
+		}
+		gtox.emit(GroupNamelistChangeEvent{int(groupNumber), int(peerNumber), ChatChange(change)})
+	})
 }
@@ -0,0 +1,268 @@
+package golibtox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"weak"
+)
+
+// ProfileStore keeps a profile in a file, replaced atomically so that a
+// crash leaves either the old or the new profile, and its last backups.
+type ProfileStore struct {
+	path    string
+	backups int
+
+	mtx sync.Mutex
+}
+
+// NewProfileStore returns a ProfileStore saving to path, keeping the
+// previous profiles as path.1 (the newest) to path.backups.
+func NewProfileStore(path string, backups int) *ProfileStore {
+	return &ProfileStore{path: path, backups: backups}
+}
+
+func (s *ProfileStore) Path() string {
+	return s.path
+}
+
+// Load returns the saved profile, or the newest backup if the profile
+// file does not exist. It only falls back when the file is missing: an
+// unreadable file is an error, and a corrupt one is returned as it is,
+// Load knowing nothing of its format.
+func (s *ProfileStore) Load() ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+
+	for i := 1; i <= s.backups; i++ {
+		if backup, berr := os.ReadFile(s.backup(i)); berr == nil {
+			return backup, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Save replaces the profile with data, only readable by its owner. It is
+// a SaveFunc, to be given to Run or SetAutosave.
+func (s *ProfileStore) Save(data []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	dir := filepath.Dir(s.path)
+
+	// Created with mode 0600
+	f, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.rotate()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+func (s *ProfileStore) backup(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
+
+// rotate shifts the backups and makes the current profile the newest one,
+// leaving it in place until it is replaced.
+func (s *ProfileStore) rotate() error {
+	if s.backups <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(s.path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	os.Remove(s.backup(s.backups))
+	for i := s.backups - 1; i >= 1; i-- {
+		err := os.Rename(s.backup(i), s.backup(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.Link(s.path, s.backup(1)); err == nil {
+		return nil
+	}
+
+	// No hard links on this file system
+	return copyProfile(s.path, s.backup(1))
+}
+
+func copyProfile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+type autosaver struct {
+	save  SaveFunc
+	delay time.Duration
+	dirty chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// SetAutosave makes t pass its state to save, such as ProfileStore.Save,
+// delay after a change by AddFriend, AddFriendNorequest, DelFriend,
+// SetName or SetNospam, the delay starting over at each change. A failed
+// save is retried after delay. Close saves the last changes. A nil save
+// stops autosaving.
+func (t *Tox) SetAutosave(save SaveFunc, delay time.Duration) error {
+	var a *autosaver
+	if save != nil {
+		a = &autosaver{
+			save:  save,
+			delay: delay,
+			dirty: make(chan struct{}, 1),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+	}
+
+	t.mtx.Lock()
+	if t.tox == nil {
+		t.mtx.Unlock()
+		return t.nilError()
+	}
+	old := t.autosave
+	t.autosave = a
+	t.mtx.Unlock()
+
+	old.close()
+	if a != nil {
+		// Only a weak pointer, so that t can still be collected
+		go a.run(weak.Make(t), t.collected)
+	}
+
+	return nil
+}
+
+// changed tells the autosaver that the state of t has changed.
+// t.mtx must be held.
+func (t *Tox) changed() {
+	if t.autosave == nil {
+		return
+	}
+
+	select {
+	case t.autosave.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Tox) stopAutosave() {
+	t.mtx.Lock()
+	a := t.autosave
+	t.autosave = nil
+	t.mtx.Unlock()
+
+	a.close()
+}
+
+func (a *autosaver) run(tox weak.Pointer[Tox], collected <-chan struct{}) {
+	defer close(a.done)
+
+	timer := time.NewTimer(a.delay)
+	timer.Stop()
+	pending := false
+
+	for {
+		select {
+		case <-a.dirty:
+			pending = true
+			timer.Reset(a.delay)
+		case <-timer.C:
+			switch err := a.flush(tox); err {
+			case nil:
+				pending = false
+			case ErrClosed:
+				// Nothing left to save
+				return
+			default:
+				timer.Reset(a.delay)
+			}
+		case <-a.stop:
+			if pending {
+				a.flush(tox)
+			}
+			return
+		case <-collected:
+			return
+		}
+	}
+}
+
+func (a *autosaver) flush(tox weak.Pointer[Tox]) error {
+	t := tox.Value()
+	if t == nil {
+		return ErrClosed
+	}
+
+	data, err := t.Save()
+	if err != nil {
+		return err
+	}
+
+	return a.save(data)
+}
+
+// close stops a, saving the pending changes.
+func (a *autosaver) close() {
+	if a == nil {
+		return
+	}
+
+	close(a.stop)
+	<-a.done
+}
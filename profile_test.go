@@ -0,0 +1,111 @@
+package golibtox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProfileStoreSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.tox")
+	s := NewProfileStore(path, 2)
+
+	for i := 1; i <= 4; i++ {
+		if err := s.Save([]byte("profile " + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		path:        "profile 4",
+		path + ".1": "profile 3",
+		path + ".2": "profile 2",
+	}
+	for name, want := range files {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), data, err, want)
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		// Backups are links to, or copies of, saved profiles
+		if mode := fi.Mode().Perm(); mode != 0600 {
+			t.Errorf("%s has mode %v, want 0600", filepath.Base(name), mode)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if _, ok := files[filepath.Join(dir, e.Name())]; !ok {
+			t.Errorf("%s left in the directory", e.Name())
+		}
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+
+	data, err := s.Load()
+	if err != nil || string(data) != "profile 4" {
+		t.Errorf("Load = %q, %v, want %q", data, err, "profile 4")
+	}
+}
+
+func TestProfileStoreNoBackups(t *testing.T) {
+	dir := t.TempDir()
+	s := NewProfileStore(filepath.Join(dir, "profile.tox"), 0)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Save([]byte("profile")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d files, want only the profile", len(entries))
+	}
+}
+
+func TestProfileStoreLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.tox")
+	s := NewProfileStore(path, 3)
+
+	if _, err := s.Load(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load of nothing = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	for _, data := range []string{"first", "second", "third"} {
+		if err := s.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The profile is lost
+	os.Remove(path)
+	if data, err := s.Load(); err != nil || string(data) != "second" {
+		t.Errorf("Load without profile = %q, %v, want %q", data, err, "second")
+	}
+
+	// The newest backup is gone too
+	os.Remove(path + ".1")
+	if data, err := s.Load(); err != nil || string(data) != "first" {
+		t.Errorf("Load without profile and path.1 = %q, %v, want %q", data, err, "first")
+	}
+
+	// Only a missing profile falls back
+	os.WriteFile(path, []byte("corrupt"), 0600)
+	if data, err := s.Load(); err != nil || string(data) != "corrupt" {
+		t.Errorf("Load of a corrupt profile = %q, %v, want %q", data, err, "corrupt")
+	}
+}
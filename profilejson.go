@@ -0,0 +1,254 @@
+package golibtox
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/organ/golibtox/toxstate"
+)
+
+const (
+	PROFILE_DOCUMENT_VERSION = 1
+
+	profileKDF        = "pbkdf2-sha256"
+	profileCipher     = "aes-256-gcm"
+	profileIterations = 600000
+	profileSaltSize   = 16
+)
+
+// ProfileDocument is the portable JSON form of a profile, made by
+// ExportProfile and read by ImportProfile. Exactly one of SecretKey and
+// EncryptedSecretKey is set.
+type ProfileDocument struct {
+	Version   int       `json:"version"`
+	PublicKey PublicKey `json:"public_key"`
+	// Uppercase hex
+	SecretKey          string          `json:"secret_key,omitempty"`
+	EncryptedSecretKey *EncryptedKey   `json:"encrypted_secret_key,omitempty"`
+	Nospam             uint32          `json:"nospam"`
+	Name               string          `json:"name"`
+	StatusMessage      string          `json:"status_message"`
+	UserStatus         UserStatus      `json:"user_status"`
+	Friends            []ProfileFriend `json:"friends"`
+}
+
+type ProfileFriend struct {
+	PublicKey PublicKey `json:"public_key"`
+	// Local name given to the friend, not known by toxcore
+	Alias      string    `json:"alias,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	LastOnline time.Time `json:"last_online,omitzero"`
+}
+
+// EncryptedKey is a secret key encrypted with AES-256-GCM, with a key
+// derived from a passphrase by PBKDF2-SHA256.
+type EncryptedKey struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// ExportProfile returns the profile of t as a JSON ProfileDocument. The
+// secret key is encrypted with passphrase if it is not empty. aliases
+// gives the Alias of the friends, it can be nil.
+func (t *Tox) ExportProfile(passphrase []byte, aliases map[PublicKey]string) ([]byte, error) {
+	data, err := t.Save()
+	if err != nil {
+		return nil, err
+	}
+	state, err := toxstate.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &ProfileDocument{
+		Version:       PROFILE_DOCUMENT_VERSION,
+		PublicKey:     PublicKey(state.PublicKey),
+		Nospam:        state.Nospam,
+		Name:          state.Name,
+		StatusMessage: state.StatusMessage,
+		UserStatus:    UserStatus(state.UserStatus),
+		Friends:       []ProfileFriend{},
+	}
+
+	if len(passphrase) > 0 {
+		if doc.EncryptedSecretKey, err = encryptKey(state.SecretKey[:], passphrase); err != nil {
+			return nil, err
+		}
+	} else {
+		doc.SecretKey = state.SecretKey.String()
+	}
+
+	count, err := t.CountFriendlist()
+	if err != nil {
+		return nil, err
+	}
+	var friends []int32
+	if count > 0 {
+		if friends, err = t.GetFriendlist(); err != nil {
+			return nil, err
+		}
+	}
+	for _, friendNumber := range friends {
+		publicKey, err := t.GetClientId(friendNumber)
+		if err != nil {
+			return nil, err
+		}
+		// Empty until the friend was seen online
+		name, _ := t.GetName(friendNumber)
+		lastOnline, _ := t.GetLastOnline(friendNumber)
+		if lastOnline.Unix() <= 0 {
+			lastOnline = time.Time{}
+		}
+
+		doc.Friends = append(doc.Friends, ProfileFriend{
+			PublicKey:  publicKey,
+			Alias:      aliases[publicKey],
+			Name:       name,
+			LastOnline: lastOnline.UTC(),
+		})
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// ImportProfile loads into t the profile of a JSON ProfileDocument, its
+// secret key decrypted with passphrase if needed, and adds its friends
+// with AddFriendNorequest: friend requests not accepted yet become
+// friends. It returns the document, for the aliases.
+//
+// The document is checked before t is changed, but t should be a new Tox:
+// if adding a friend still fails, for instance because t already has it,
+// t is left with the keys of the document and only part of its friends.
+func (t *Tox) ImportProfile(data []byte, passphrase []byte) (*ProfileDocument, error) {
+	doc := new(ProfileDocument)
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != PROFILE_DOCUMENT_VERSION {
+		return nil, ErrProfileVersion
+	}
+
+	state := &toxstate.Profile{
+		Nospam:        doc.Nospam,
+		PublicKey:     toxstate.Key(doc.PublicKey),
+		Name:          doc.Name,
+		StatusMessage: doc.StatusMessage,
+		UserStatus:    uint8(doc.UserStatus),
+	}
+
+	var secretKey []byte
+	var err error
+	if doc.EncryptedSecretKey != nil {
+		secretKey, err = decryptKey(doc.EncryptedSecretKey, passphrase)
+	} else {
+		secretKey, err = hex.DecodeString(doc.SecretKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(secretKey) != len(state.SecretKey) {
+		return nil, ErrInvalidProfile
+	}
+	copy(state.SecretKey[:], secretKey)
+
+	// toxcore would silently use a mismatched pair
+	privateKey, err := ecdh.X25519().NewPrivateKey(secretKey)
+	if err != nil || !bytes.Equal(privateKey.PublicKey().Bytes(), doc.PublicKey[:]) {
+		return nil, ErrInvalidProfile
+	}
+
+	seen := make(map[PublicKey]bool, len(doc.Friends))
+	for _, friend := range doc.Friends {
+		if friend.PublicKey == doc.PublicKey || seen[friend.PublicKey] {
+			return nil, ErrInvalidProfile
+		}
+		seen[friend.PublicKey] = true
+	}
+
+	if err := t.Load(toxstate.Encode(state)); err != nil {
+		return nil, err
+	}
+
+	for _, friend := range doc.Friends {
+		if _, err := t.AddFriendNorequest(friend.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func encryptKey(key []byte, passphrase []byte) (*EncryptedKey, error) {
+	e := &EncryptedKey{
+		KDF:        profileKDF,
+		Iterations: profileIterations,
+		Salt:       make([]byte, profileSaltSize),
+		Cipher:     profileCipher,
+	}
+	rand.Read(e.Salt)
+
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(e.Nonce)
+	e.Data = aead.Seal(nil, e.Nonce, key, nil)
+
+	return e, nil
+}
+
+func decryptKey(e *EncryptedKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+	if e.KDF != profileKDF || e.Cipher != profileCipher {
+		return nil, ErrInvalidProfile
+	}
+	// Read from the document: bounded, or deriving the key could take hours
+	if e.Iterations < 1 || e.Iterations > 10*profileIterations || len(e.Salt) == 0 {
+		return nil, ErrInvalidProfile
+	}
+
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, ErrInvalidProfile
+	}
+
+	key, err := aead.Open(nil, e.Nonce, e.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return key, nil
+}
+
+func (e *EncryptedKey) aead(passphrase []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), e.Salt, e.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
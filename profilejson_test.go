@@ -0,0 +1,56 @@
+package golibtox
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptedKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	e, err := encryptKey(key, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decryptKey(e, []byte("secret"))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("decryptKey = %x, %v, want %x", got, err, key)
+	}
+	if _, err := decryptKey(e, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("decryptKey with a wrong passphrase = %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, err := decryptKey(e, nil); err != ErrEmptyPassphrase {
+		t.Errorf("decryptKey with no passphrase = %v, want %v", err, ErrEmptyPassphrase)
+	}
+}
+
+func TestEncryptedKeyInvalid(t *testing.T) {
+	valid := EncryptedKey{
+		KDF:        profileKDF,
+		Iterations: profileIterations,
+		Salt:       make([]byte, profileSaltSize),
+		Cipher:     profileCipher,
+		Nonce:      make([]byte, 12),
+	}
+
+	tests := []struct {
+		name   string
+		modify func(e *EncryptedKey)
+	}{
+		{"unknown kdf", func(e *EncryptedKey) { e.KDF = "scrypt" }},
+		{"unknown cipher", func(e *EncryptedKey) { e.Cipher = "aes-128-cbc" }},
+		{"no iterations", func(e *EncryptedKey) { e.Iterations = 0 }},
+		{"negative iterations", func(e *EncryptedKey) { e.Iterations = -1 }},
+		{"too many iterations", func(e *EncryptedKey) { e.Iterations = 2147483647 }},
+		{"no salt", func(e *EncryptedKey) { e.Salt = nil }},
+		{"short nonce", func(e *EncryptedKey) { e.Nonce = e.Nonce[:8] }},
+	}
+
+	for _, tt := range tests {
+		e := valid
+		tt.modify(&e)
+		if _, err := decryptKey(&e, []byte("secret")); err != ErrInvalidProfile {
+			t.Errorf("%s: decryptKey = %v, want %v", tt.name, err, ErrInvalidProfile)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package golibtox
+
+import "time"
+
+// Minimum delay between two progress reports of a transfer, unless it is
+// paused, resumed or ends.
+const progressInterval = 500 * time.Millisecond
+
+// Progress is a snapshot of a Transfer.
+type Progress struct {
+	Transferred uint64
+	Size        uint64
+	// Bytes per second, smoothed over the last reports
+	Rate float64
+	// Estimated time left, 0 if unknown
+	ETA time.Duration
+	// Paused by a friend, or broken until the friend comes back online
+	Paused bool
+	Done   bool
+}
+
+type ProgressFunc func(p Progress)
+
+type progressFunc struct {
+	f ProgressFunc
+}
+
+// progressState is the part of a Transfer used to compute the rate and
+// decide when to report.
+type progressState struct {
+	funcs           []*progressFunc
+	rate            float64
+	lastSample      time.Time
+	lastTransferred uint64
+	lastPaused      bool
+	reportedDone    bool
+}
+
+func (tr *Transfer) Progress() Progress {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.progress()
+}
+
+// OnProgress adds f to the funcs called by Do with the progress of tr, at
+// most every progressInterval and when it is paused, resumed or ends.
+// It returns a function removing f.
+func (tr *Transfer) OnProgress(f ProgressFunc) func() {
+	pf := &progressFunc{f}
+
+	tr.mtx.Lock()
+	tr.prog.funcs = append(tr.prog.funcs, pf)
+	tr.mtx.Unlock()
+
+	return func() {
+		tr.mtx.Lock()
+		defer tr.mtx.Unlock()
+
+		for i, p := range tr.prog.funcs {
+			if p == pf {
+				tr.prog.funcs = append(tr.prog.funcs[:i:i], tr.prog.funcs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// progress builds the current Progress. tr.mtx must be held.
+func (tr *Transfer) progress() Progress {
+	p := Progress{
+		Transferred: tr.transferred,
+		Size:        tr.size,
+		Rate:        tr.prog.rate,
+		Paused:      tr.state == transferPaused || tr.state == transferBroken,
+		Done:        tr.state == transferDone,
+	}
+
+	if p.Rate > 0 && p.Size > p.Transferred && !p.Done {
+		p.ETA = time.Duration(float64(p.Size-p.Transferred) / p.Rate * float64(time.Second))
+	}
+
+	return p
+}
+
+// notifyProgress updates the rate of tr and calls its ProgressFuncs when
+// a report is due.
+func (tr *Transfer) notifyProgress(now time.Time) {
+	tr.mtx.Lock()
+
+	due := false
+	if elapsed := now.Sub(tr.prog.lastSample); elapsed >= progressInterval {
+		var instant float64
+		if tr.transferred > tr.prog.lastTransferred {
+			instant = float64(tr.transferred-tr.prog.lastTransferred) / elapsed.Seconds()
+		}
+
+		if tr.prog.lastSample.Equal(tr.started) {
+			tr.prog.rate = instant
+		} else {
+			tr.prog.rate = 0.7*tr.prog.rate + 0.3*instant
+		}
+
+		tr.prog.lastSample = now
+		tr.prog.lastTransferred = tr.transferred
+		due = true
+	}
+
+	p := tr.progress()
+	if p.Paused != tr.prog.lastPaused {
+		tr.prog.lastPaused = p.Paused
+		due = true
+	}
+
+	if p.Done {
+		if tr.prog.reportedDone {
+			due = false
+		} else {
+			tr.prog.reportedDone = true
+			due = true
+		}
+	}
+
+	funcs := tr.prog.funcs
+	tr.mtx.Unlock()
+
+	if !due {
+		return
+	}
+
+	for _, pf := range funcs {
+		pf.f(p)
+	}
+}
+
+// notifyProgress is called by Do once the transfers have been pumped.
+func (t *Tox) notifyProgress() {
+	now := time.Now()
+
+	t.trmtx.Lock()
+	finished := t.finished
+	t.finished = nil
+	t.trmtx.Unlock()
+
+	for _, tr := range append(t.friendTransfers(-1), finished...) {
+		tr.notifyProgress(now)
+	}
+}
@@ -0,0 +1,21 @@
+package golibtox
+
+import (
+	"runtime/cgo"
+	"weak"
+)
+
+// Go pointers to a Tox cannot be handed to toxcore as callback userdata,
+// cgo forbids C from keeping them. Each Tox is given a cgo.Handle instead,
+// which the hooks use to find it back.
+// The handle only holds a weak pointer, so that it does not keep an
+// unreachable Tox from being collected and its toxcore instance killed.
+func register(t *Tox) cgo.Handle {
+	return cgo.NewHandle(weak.Make(t))
+}
+
+// lookup returns the Tox of handle, or nil if it has been collected.
+// handle must not have been deleted.
+func lookup(handle cgo.Handle) *Tox {
+	return handle.Value().(weak.Pointer[Tox]).Value()
+}
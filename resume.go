@@ -0,0 +1,152 @@
+package golibtox
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Received bytes after which the progress of an incoming transfer is
+// recorded again to the transfer state file.
+const checkpointInterval = 1 << 20
+
+// PartialTransfer is an incoming transfer recorded in the transfer state
+// file, not received completely yet.
+type PartialTransfer struct {
+	PublicKey PublicKey `json:"public_key"`
+	Filename  string    `json:"filename"`
+	Size      uint64    `json:"size"`
+	Received  uint64    `json:"received"`
+	// Where a Downloader saves the file, to continue writing it
+	Path string `json:"path,omitempty"`
+}
+
+type partialKey struct {
+	publicKey PublicKey
+	filename  string
+	size      uint64
+}
+
+// SetTransferStateFile makes the library record the progress of incoming
+// transfers to path, loading the transfers already recorded there.
+//
+// Within a run, a transfer broken because the friend went offline is
+// resumed with FILECONTROL_RESUME_BROKEN when the friend comes back, see
+// SetBrokenTransferTimeout.
+//
+// This does not make transfers resumable across restarts: toxcore cannot
+// resume a transfer started by a previous run. When the friend sends the
+// same file again, all of it is transferred again from byte 0; only the
+// bytes written before are kept and not written again, see
+// Transfer.Offset.
+func (t *Tox) SetTransferStateFile(path string) error {
+	partials := make(map[partialKey]PartialTransfer)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(data) > 0 {
+		var list []PartialTransfer
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		for _, p := range list {
+			partials[partialKey{p.PublicKey, p.Filename, p.Size}] = p
+		}
+	}
+
+	t.trmtx.Lock()
+	t.statePath = path
+	t.partials = partials
+	t.trmtx.Unlock()
+
+	return nil
+}
+
+func (t *Tox) PartialTransfers() []PartialTransfer {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	list := make([]PartialTransfer, 0, len(t.partials))
+	for _, p := range t.partials {
+		list = append(list, p)
+	}
+
+	return list
+}
+
+// partial returns the recorded progress of filename from friendNumber.
+func (t *Tox) partial(publicKey PublicKey, filename []byte, size uint64) *PartialTransfer {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	p, ok := t.partials[partialKey{publicKey, string(filename), size}]
+	if !ok || p.Received >= size {
+		return nil
+	}
+
+	return &p
+}
+
+// savePartial records the progress of the incoming transfer tr.
+// tr.mtx must be held.
+func (t *Tox) savePartial(tr *Transfer) {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	if t.statePath == "" {
+		return
+	}
+
+	key := partialKey{tr.publicKey, string(tr.filename), tr.size}
+	t.partials[key] = PartialTransfer{tr.publicKey, string(tr.filename), tr.size, tr.transferred, tr.path}
+	t.writeTransferState()
+}
+
+// removePartial forgets the incoming transfer tr once it has ended.
+// tr.mtx must be held.
+func (t *Tox) removePartial(tr *Transfer) {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	key := partialKey{tr.publicKey, string(tr.filename), tr.size}
+	if _, ok := t.partials[key]; !ok {
+		return
+	}
+
+	delete(t.partials, key)
+	t.writeTransferState()
+}
+
+// writeTransferState replaces the transfer state file. A failure only
+// loses progress, so it is not reported. t.trmtx must be held.
+func (t *Tox) writeTransferState() {
+	list := make([]PartialTransfer, 0, len(t.partials))
+	for _, p := range t.partials {
+		list = append(list, p)
+	}
+
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return
+	}
+
+	tmp := t.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, t.statePath)
+}
+
+// restart makes an incoming transfer recorded in the transfer state file
+// write everything it receives again, from the start, for the receivers of
+// the library which cannot continue at Offset. They call it from within
+// the AcceptFileFunc, before the transfer is accepted.
+func (tr *Transfer) restart() {
+	tr.offset = 0
+	tr.skip = 0
+	tr.transferred = 0
+	tr.checkpoint = 0
+	tr.prog.lastTransferred = 0
+}
@@ -0,0 +1,175 @@
+package golibtox
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Smallest burst allowed by a rate limit, so that a whole chunk of file
+// data always fits even at low rates.
+const minBurst = 4096
+
+// tokenBucket limits a byte rate, allowing bursts of a quarter second.
+type tokenBucket struct {
+	rate   float64 // Bytes per second, 0 meaning unlimited
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) burst() float64 {
+	if b.rate/4 < minBurst {
+		return minBurst
+	}
+	return b.rate / 4
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate == 0 {
+		return
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst() {
+		b.tokens = b.burst()
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) allow(n int) bool {
+	return b.rate == 0 || b.tokens >= float64(n)
+}
+
+func (b *tokenBucket) take(n int) {
+	if b.rate != 0 {
+		b.tokens -= float64(n)
+	}
+}
+
+// scheduler shares the send rate between the outgoing transfers.
+type scheduler struct {
+	mtx     sync.Mutex
+	global  tokenBucket
+	friends map[int32]*tokenBucket
+	next    int // Round-robin position of the first transfer pumped
+}
+
+func (s *scheduler) setRate(b *tokenBucket, bytesPerSecond int) {
+	b.rate = float64(bytesPerSecond)
+	b.tokens = b.burst()
+	b.last = time.Now()
+}
+
+func (s *scheduler) refill(now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.global.refill(now)
+	for _, b := range s.friends {
+		b.refill(now)
+	}
+}
+
+func (s *scheduler) allow(friendNumber int32, n int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if b, ok := s.friends[friendNumber]; ok && !b.allow(n) {
+		return false
+	}
+	return s.global.allow(n)
+}
+
+func (s *scheduler) take(friendNumber int32, n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if b, ok := s.friends[friendNumber]; ok {
+		b.take(n)
+	}
+	s.global.take(n)
+}
+
+// SetSendRate limits the bytes per second sent by all file transfers
+// together. 0 removes the limit, a negative rate is rejected with
+// ErrInvalidRate.
+func (t *Tox) SetSendRate(bytesPerSecond int) error {
+	if bytesPerSecond < 0 {
+		return ErrInvalidRate
+	}
+
+	t.sched.mtx.Lock()
+	defer t.sched.mtx.Unlock()
+
+	t.sched.setRate(&t.sched.global, bytesPerSecond)
+
+	return nil
+}
+
+// SetFriendSendRate limits the bytes per second sent by the file
+// transfers to friendNumber. 0 removes the limit, a negative rate is
+// rejected with ErrInvalidRate.
+func (t *Tox) SetFriendSendRate(friendNumber int32, bytesPerSecond int) error {
+	if bytesPerSecond < 0 {
+		return ErrInvalidRate
+	}
+
+	t.sched.mtx.Lock()
+	defer t.sched.mtx.Unlock()
+
+	if bytesPerSecond == 0 {
+		delete(t.sched.friends, friendNumber)
+		return nil
+	}
+
+	if t.sched.friends == nil {
+		t.sched.friends = make(map[int32]*tokenBucket)
+	}
+	b := &tokenBucket{}
+	t.sched.setRate(b, bytesPerSecond)
+	t.sched.friends[friendNumber] = b
+
+	return nil
+}
+
+// pumpTransfers is called by Do once the callbacks have been run. Each
+// outgoing transfer sends one chunk in turn, until the rate limits or the
+// toxcore queues stop them all. The first transfer served changes at
+// every call, so that none is favoured when the limits are reached.
+func (t *Tox) pumpTransfers() {
+	var trs []*Transfer
+	for _, tr := range t.friendTransfers(-1) {
+		if tr.sending {
+			trs = append(trs, tr)
+		}
+	}
+
+	if len(trs) == 0 {
+		return
+	}
+
+	sort.Slice(trs, func(i, j int) bool {
+		if trs[i].friendNumber != trs[j].friendNumber {
+			return trs[i].friendNumber < trs[j].friendNumber
+		}
+		return trs[i].filenumber < trs[j].filenumber
+	})
+
+	t.sched.refill(time.Now())
+
+	t.sched.mtx.Lock()
+	start := t.sched.next % len(trs)
+	t.sched.next++
+	t.sched.mtx.Unlock()
+
+	active := append(trs[start:], trs[:start]...)
+	for len(active) > 0 {
+		var again []*Transfer
+		for _, tr := range active {
+			if tr.pump() {
+				again = append(again, tr)
+			}
+		}
+		active = again
+	}
+}
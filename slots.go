@@ -0,0 +1,120 @@
+package golibtox
+
+// FreeFileSlots returns how many filenumbers with friendNumber are free for
+// transfers in the given direction. Only the transfers handled by the
+// library, with SendFile or AcceptFile, are counted.
+func (t *Tox) FreeFileSlots(friendNumber int32, sending bool) int {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	n := MAX_CONCURRENT_FILE_PIPES
+	for key := range t.transfers {
+		if key.friendNumber == friendNumber && key.sending == sending {
+			n--
+		}
+	}
+
+	return n
+}
+
+// offer asks toxcore for a filenumber to send tr with.
+// t.trmtx must be held.
+func (t *Tox) offer(tr *Transfer) error {
+	n, err := t.NewFileSender(tr.friendNumber, tr.size, tr.filename)
+	if err != nil {
+		return err
+	}
+
+	tr.filenumber = uint8(n)
+	tr.state = transferPending
+	t.transfers[tr.key()] = tr
+
+	return nil
+}
+
+// enqueue makes tr wait for a free filenumber. t.trmtx must be held.
+func (t *Tox) enqueue(tr *Transfer) {
+	if t.queued == nil {
+		t.queued = make(map[int32][]*Transfer)
+	}
+	t.queued[tr.friendNumber] = append(t.queued[tr.friendNumber], tr)
+}
+
+// dequeue removes tr from the queue of its friend, returning whether it
+// was there. t.trmtx must be held.
+func (t *Tox) dequeue(tr *Transfer) bool {
+	q := t.queued[tr.friendNumber]
+	for i, queued := range q {
+		if queued == tr {
+			if len(q) == 1 {
+				delete(t.queued, tr.friendNumber)
+			} else {
+				// Copy so that startQueued snapshots are never modified
+				t.queued[tr.friendNumber] = append(q[:i:i], q[i+1:]...)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Tox) queuedTransfers() []*Transfer {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	var trs []*Transfer
+	for _, q := range t.queued {
+		trs = append(trs, q...)
+	}
+
+	return trs
+}
+
+// startQueued offers the queued transfers, in order, as long as toxcore
+// has free filenumbers for their friend. Called by Do.
+func (t *Tox) startQueued() {
+	t.trmtx.Lock()
+	queues := make([][]*Transfer, 0, len(t.queued))
+	for _, q := range t.queued {
+		queues = append(queues, q)
+	}
+	t.trmtx.Unlock()
+
+	for _, q := range queues {
+		for _, tr := range q {
+			if !tr.start() {
+				break
+			}
+		}
+	}
+}
+
+// start offers the queued tr, unless it has ended in the meantime. It
+// returns false when the next transfers queued for its friend must wait.
+func (tr *Transfer) start() bool {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.state != transferQueued {
+		return true
+	}
+
+	t := tr.tox
+	t.trmtx.Lock()
+	err := t.offer(tr)
+	if err == nil {
+		t.dequeue(tr)
+	}
+	t.trmtx.Unlock()
+
+	switch err {
+	case nil:
+		return true
+	case ErrNoFileSlot, ErrFriendNotConnected:
+		return false
+	}
+
+	tr.finish(err)
+	return true
+}
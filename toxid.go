@@ -0,0 +1,120 @@
+package golibtox
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+)
+
+// PublicKey is the long term public key of a Tox user, also called
+// client id by toxcore.
+type PublicKey [CLIENT_ID_SIZE]byte
+
+// ToxID is the address given out to be added as a friend: the public key,
+// followed by the nospam value and a checksum of both.
+type ToxID [FRIEND_ADDRESS_SIZE]byte
+
+func ParsePublicKey(s string) (PublicKey, error) {
+	var pk PublicKey
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(pk) {
+		return pk, ErrInvalidClientId
+	}
+	copy(pk[:], b)
+
+	return pk, nil
+}
+
+func (pk PublicKey) String() string {
+	return strings.ToUpper(hex.EncodeToString(pk[:]))
+}
+
+func (pk PublicKey) MarshalText() ([]byte, error) {
+	return []byte(pk.String()), nil
+}
+
+func (pk *PublicKey) UnmarshalText(text []byte) error {
+	p, err := ParsePublicKey(string(text))
+	if err != nil {
+		return err
+	}
+	*pk = p
+	return nil
+}
+
+// NewToxID builds the ToxID of pk with the given nospam.
+func NewToxID(pk PublicKey, nospam uint32) ToxID {
+	var id ToxID
+
+	copy(id[:], pk[:])
+	binary.NativeEndian.PutUint32(id[CLIENT_ID_SIZE:], nospam)
+	sum := id.checksum()
+	copy(id[CLIENT_ID_SIZE+4:], sum[:])
+
+	return id
+}
+
+// ParseToxID decodes a hex ToxID and verifies its checksum.
+func ParseToxID(s string) (ToxID, error) {
+	var id ToxID
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, ErrInvalidAddress
+	}
+	copy(id[:], b)
+
+	if !id.Valid() {
+		return id, FAERR_BADCHECKSUM
+	}
+
+	return id, nil
+}
+
+func (id ToxID) PublicKey() PublicKey {
+	var pk PublicKey
+	copy(pk[:], id[:CLIENT_ID_SIZE])
+	return pk
+}
+
+// Nospam and Checksum are stored in host byte order, as toxcore does.
+func (id ToxID) Nospam() uint32 {
+	return binary.NativeEndian.Uint32(id[CLIENT_ID_SIZE:])
+}
+
+func (id ToxID) Checksum() uint16 {
+	return binary.NativeEndian.Uint16(id[CLIENT_ID_SIZE+4:])
+}
+
+// Valid reports whether the checksum of id matches its content.
+func (id ToxID) Valid() bool {
+	sum := id.checksum()
+	return sum[0] == id[CLIENT_ID_SIZE+4] && sum[1] == id[CLIENT_ID_SIZE+5]
+}
+
+// checksum XORs the public key and nospam two bytes at a time.
+func (id ToxID) checksum() [2]byte {
+	var sum [2]byte
+	for i, b := range id[:CLIENT_ID_SIZE+4] {
+		sum[i%2] ^= b
+	}
+	return sum
+}
+
+func (id ToxID) String() string {
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+func (id ToxID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ToxID) UnmarshalText(text []byte) error {
+	i, err := ParseToxID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = i
+	return nil
+}
@@ -0,0 +1,114 @@
+package golibtox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// The public Tox ID of groupbot
+const testToxID = "56A1ADE4B65B86BCD51CC73E2CD4E542179F47959FE3E0E21B4B0ACDADE51855D34D34D37CB5"
+
+func TestParseToxID(t *testing.T) {
+	id, err := ParseToxID(testToxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.Valid() {
+		t.Error("Valid = false")
+	}
+	if s := id.String(); s != testToxID {
+		t.Errorf("String = %s, want %s", s, testToxID)
+	}
+
+	// Lowercase is accepted too
+	if lower, err := ParseToxID(strings.ToLower(testToxID)); err != nil || lower != id {
+		t.Errorf("ParseToxID of lowercase = %s, %v", lower, err)
+	}
+
+	pk, err := ParsePublicKey(testToxID[:2*CLIENT_ID_SIZE])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.PublicKey() != pk {
+		t.Errorf("PublicKey = %s, want %s", id.PublicKey(), pk)
+	}
+
+	// Stored in host byte order
+	nospam := binary.NativeEndian.Uint32([]byte{0xd3, 0x4d, 0x34, 0xd3})
+	if id.Nospam() != nospam {
+		t.Errorf("Nospam = %#x, want %#x", id.Nospam(), nospam)
+	}
+	checksum := binary.NativeEndian.Uint16([]byte{0x7c, 0xb5})
+	if id.Checksum() != checksum {
+		t.Errorf("Checksum = %#x, want %#x", id.Checksum(), checksum)
+	}
+
+	if built := NewToxID(pk, id.Nospam()); built != id {
+		t.Errorf("NewToxID = %s, want %s", built, id)
+	}
+}
+
+func TestParseToxIDInvalid(t *testing.T) {
+	badChecksum := testToxID[:len(testToxID)-1] + "4"
+	badNospam := testToxID[:2*CLIENT_ID_SIZE] + "D34D34D4" + testToxID[2*CLIENT_ID_SIZE+8:]
+
+	tests := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"empty", "", ErrInvalidAddress},
+		{"public key only", testToxID[:2*CLIENT_ID_SIZE], ErrInvalidAddress},
+		{"short", testToxID[:len(testToxID)-2], ErrInvalidAddress},
+		{"long", testToxID + "00", ErrInvalidAddress},
+		{"odd length", testToxID[:len(testToxID)-1], ErrInvalidAddress},
+		{"not hex", "X" + testToxID[1:], ErrInvalidAddress},
+		{"spaces", " " + testToxID[1:], ErrInvalidAddress},
+		{"bad checksum", badChecksum, FAERR_BADCHECKSUM},
+		{"changed nospam", badNospam, FAERR_BADCHECKSUM},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseToxID(tt.id); err != tt.err {
+			t.Errorf("%s: ParseToxID = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestToxIDText(t *testing.T) {
+	id, err := ParseToxID(testToxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(map[string]ToxID{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":"` + testToxID + `"}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	var decoded map[string]ToxID
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded["id"] != id {
+		t.Errorf("Unmarshal = %s, %v, want %s", decoded["id"], err, id)
+	}
+
+	var bad ToxID
+	if err := bad.UnmarshalText([]byte(testToxID[:len(testToxID)-1] + "4")); err != FAERR_BADCHECKSUM {
+		t.Errorf("UnmarshalText of a bad checksum = %v, want %v", err, FAERR_BADCHECKSUM)
+	}
+
+	var pk PublicKey
+	if err := pk.UnmarshalText([]byte(testToxID[:2*CLIENT_ID_SIZE])); err != nil || pk != id.PublicKey() {
+		t.Errorf("PublicKey.UnmarshalText = %s, %v", pk, err)
+	}
+	if text, _ := pk.MarshalText(); string(text) != testToxID[:2*CLIENT_ID_SIZE] {
+		t.Errorf("PublicKey.MarshalText = %s", text)
+	}
+	if err := pk.UnmarshalText([]byte("00")); err != ErrInvalidClientId {
+		t.Errorf("PublicKey.UnmarshalText of a short key = %v, want %v", err, ErrInvalidClientId)
+	}
+}
@@ -0,0 +1,42 @@
+package toxstate
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Encode returns the state toxcore loads as p: its keys, nospam, name,
+// status message, user status and Raw sections. Friends are left out, to
+// be added once loaded. Integers are written as little endian.
+func Encode(p *Profile) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	data = binary.LittleEndian.AppendUint32(data, stateCookieGlobal)
+
+	keys := binary.LittleEndian.AppendUint32(nil, p.Nospam)
+	keys = append(keys, p.PublicKey[:]...)
+	keys = append(keys, p.SecretKey[:]...)
+	data = appendSection(data, TYPE_NOSPAMKEYS, keys)
+
+	data = appendSection(data, TYPE_NAME, []byte(p.Name))
+	data = appendSection(data, TYPE_STATUSMESSAGE, []byte(p.StatusMessage))
+	data = appendSection(data, TYPE_STATUS, []byte{p.UserStatus})
+
+	types := make([]int, 0, len(p.Raw))
+	for typ := range p.Raw {
+		types = append(types, int(typ))
+	}
+	sort.Ints(types)
+	for _, typ := range types {
+		data = appendSection(data, uint16(typ), p.Raw[uint16(typ)])
+	}
+
+	return data
+}
+
+func appendSection(data []byte, typ uint16, section []byte) []byte {
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(section)))
+	data = binary.LittleEndian.AppendUint16(data, typ)
+	data = binary.LittleEndian.AppendUint16(data, stateCookieType)
+
+	return append(data, section...)
+}
@@ -0,0 +1,249 @@
+// Package toxstate decodes the state saved by toxcore, as returned by
+// golibtox's Tox.Save, without toxcore. Integers are stored in the byte
+// order of the machine that saved the state: this package reads them as
+// little endian.
+package toxstate
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	KEY_SIZE     = 32
+	ADDRESS_SIZE = KEY_SIZE + 4 + 2
+
+	// Cookies from Messenger.c
+	stateCookieGlobal = 0x15ed1b1f
+	stateCookieType   = 0x01ce
+)
+
+// Section types
+const (
+	TYPE_NOSPAMKEYS    = 1
+	TYPE_DHT           = 2
+	TYPE_FRIENDS       = 3
+	TYPE_NAME          = 4
+	TYPE_STATUSMESSAGE = 5
+	TYPE_STATUS        = 6
+	TYPE_TCP_RELAY     = 10
+	TYPE_PATH_NODE     = 11
+	TYPE_END           = 255
+)
+
+// Layout of Messenger.c's struct SAVED_FRIEND
+const (
+	savedFriendSize = 2216
+
+	friendStatusOffset              = 0
+	friendClientIdOffset            = 1
+	friendInfoOffset                = 33
+	friendInfoSize                  = 1024
+	friendInfoSizeOffset            = 1058
+	friendNameOffset                = 1060
+	friendNameSize                  = 128
+	friendNameLengthOffset          = 1188
+	friendStatusMessageOffset       = 1190
+	friendStatusMessageSize         = 1007
+	friendStatusMessageLengthOffset = 2198
+	friendUserStatusOffset          = 2200
+	friendRequestNospamOffset       = 2204
+	friendPingLastrecvOffset        = 2208
+)
+
+// Magic number starting the states encrypted by toxencryptsave.
+var encryptedMagic = []byte("toxEsave")
+
+var (
+	ErrFormat    = errors.New("Not a toxcore state")
+	ErrTruncated = errors.New("Truncated toxcore state")
+	ErrEncrypted = errors.New("Encrypted toxcore state")
+)
+
+// Key is a public or secret key, encoded as uppercase hex in JSON.
+type Key [KEY_SIZE]byte
+
+func (k Key) String() string {
+	return strings.ToUpper(hex.EncodeToString(k[:]))
+}
+
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+type FriendStatus uint8
+
+const (
+	FRIEND_NONE FriendStatus = iota
+	// Friend request sent, not answered yet
+	FRIEND_ADDED
+	FRIEND_REQUESTED
+	FRIEND_CONFIRMED
+	FRIEND_ONLINE
+)
+
+var friendStatusNames = []string{"none", "added", "requested", "confirmed", "online"}
+
+func (s FriendStatus) String() string {
+	if int(s) < len(friendStatusNames) {
+		return friendStatusNames[s]
+	}
+	return "unknown"
+}
+
+func (s FriendStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Friend struct {
+	Status    FriendStatus
+	PublicKey Key
+	// Message of the friend request sent, if not accepted yet
+	RequestMessage string `json:",omitempty"`
+	Name           string
+	StatusMessage  string
+	UserStatus     uint8
+	// Nospam of the address the friend request was sent to
+	RequestNospam uint32
+	LastSeen      time.Time
+}
+
+type Profile struct {
+	Address       string
+	Nospam        uint32
+	PublicKey     Key
+	SecretKey     Key `json:"-"`
+	Name          string
+	StatusMessage string
+	UserStatus    uint8
+	Friends       []Friend
+	// Sections kept as they are, by type
+	Raw map[uint16][]byte `json:"-"`
+}
+
+// Decode returns the profile saved in data.
+func Decode(data []byte) (*Profile, error) {
+	if len(data) >= len(encryptedMagic) && string(data[:len(encryptedMagic)]) == string(encryptedMagic) {
+		return nil, ErrEncrypted
+	}
+
+	if len(data) < 8 {
+		return nil, ErrTruncated
+	}
+	if binary.LittleEndian.Uint32(data) != 0 || binary.LittleEndian.Uint32(data[4:]) != stateCookieGlobal {
+		return nil, ErrFormat
+	}
+	data = data[8:]
+
+	p := &Profile{Raw: make(map[uint16][]byte)}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, ErrTruncated
+		}
+		length := binary.LittleEndian.Uint32(data)
+		typ := binary.LittleEndian.Uint16(data[4:])
+		if binary.LittleEndian.Uint16(data[6:]) != stateCookieType {
+			return nil, ErrFormat
+		}
+		data = data[8:]
+
+		if uint64(length) > uint64(len(data)) {
+			return nil, ErrTruncated
+		}
+		section := data[:length]
+		data = data[length:]
+
+		if typ == TYPE_END {
+			break
+		}
+		if err := p.decodeSection(typ, section); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.PublicKey != (Key{}) {
+		p.Address = address(p.PublicKey, p.Nospam)
+	}
+
+	return p, nil
+}
+
+func (p *Profile) decodeSection(typ uint16, section []byte) error {
+	switch typ {
+	case TYPE_NOSPAMKEYS:
+		if len(section) != 4+2*KEY_SIZE {
+			return ErrFormat
+		}
+		p.Nospam = binary.LittleEndian.Uint32(section)
+		copy(p.PublicKey[:], section[4:])
+		copy(p.SecretKey[:], section[4+KEY_SIZE:])
+	case TYPE_FRIENDS:
+		if len(section)%savedFriendSize != 0 {
+			return ErrFormat
+		}
+		for i := 0; i < len(section); i += savedFriendSize {
+			p.Friends = append(p.Friends, decodeFriend(section[i:i+savedFriendSize]))
+		}
+	case TYPE_NAME:
+		p.Name = string(section)
+	case TYPE_STATUSMESSAGE:
+		p.StatusMessage = string(section)
+	case TYPE_STATUS:
+		if len(section) != 1 {
+			return ErrFormat
+		}
+		p.UserStatus = section[0]
+	default:
+		p.Raw[typ] = section
+	}
+
+	return nil
+}
+
+func decodeFriend(b []byte) Friend {
+	f := Friend{
+		Status:        FriendStatus(b[friendStatusOffset]),
+		Name:          field(b, friendNameOffset, friendNameSize, friendNameLengthOffset),
+		StatusMessage: field(b, friendStatusMessageOffset, friendStatusMessageSize, friendStatusMessageLengthOffset),
+		UserStatus:    b[friendUserStatusOffset],
+		RequestNospam: binary.LittleEndian.Uint32(b[friendRequestNospamOffset:]),
+	}
+	copy(f.PublicKey[:], b[friendClientIdOffset:])
+
+	if f.Status < FRIEND_CONFIRMED {
+		f.RequestMessage = field(b, friendInfoOffset, friendInfoSize, friendInfoSizeOffset)
+	}
+
+	if lastrecv := binary.LittleEndian.Uint64(b[friendPingLastrecvOffset:]); lastrecv != 0 {
+		f.LastSeen = time.Unix(int64(lastrecv), 0).UTC()
+	}
+
+	return f
+}
+
+// field returns the string of at most size bytes at offset, its length
+// being the uint16 at lengthOffset, which toxcore stores in network byte
+// order.
+func field(b []byte, offset, size, lengthOffset int) string {
+	length := int(binary.BigEndian.Uint16(b[lengthOffset:]))
+	if length > size {
+		length = size
+	}
+
+	return string(b[offset : offset+length])
+}
+
+// address returns the Tox ID made of publicKey and nospam.
+func address(publicKey Key, nospam uint32) string {
+	var id [ADDRESS_SIZE]byte
+	copy(id[:], publicKey[:])
+	binary.LittleEndian.PutUint32(id[KEY_SIZE:], nospam)
+	for i, b := range id[:KEY_SIZE+4] {
+		id[KEY_SIZE+4+i%2] ^= b
+	}
+
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
@@ -0,0 +1,148 @@
+package toxstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// savedFriend returns a SAVED_FRIEND laid out as Messenger.c does on
+// x86_64, offsets written out rather than taken from the constants.
+func savedFriend(status uint8, key byte, info, name, statusMessage string, userStatus uint8, nospam uint32, lastrecv uint64) []byte {
+	b := make([]byte, 2216)
+	b[0] = status
+	copy(b[1:33], bytes.Repeat([]byte{key}, 32))
+	copy(b[33:1057], info)
+	binary.BigEndian.PutUint16(b[1058:], uint16(len(info)))
+	copy(b[1060:1188], name)
+	binary.BigEndian.PutUint16(b[1188:], uint16(len(name)))
+	copy(b[1190:2197], statusMessage)
+	binary.BigEndian.PutUint16(b[2198:], uint16(len(statusMessage)))
+	b[2200] = userStatus
+	binary.LittleEndian.PutUint32(b[2204:], nospam)
+	binary.LittleEndian.PutUint64(b[2208:], lastrecv)
+
+	return b
+}
+
+func section(typ uint16, data []byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	b = binary.LittleEndian.AppendUint16(b, typ)
+	b = binary.LittleEndian.AppendUint16(b, 0x01ce)
+
+	return append(b, data...)
+}
+
+func testState() []byte {
+	data := []byte{0, 0, 0, 0, 0x1f, 0x1b, 0xed, 0x15}
+
+	keys := binary.LittleEndian.AppendUint32(nil, 0x01020304)
+	keys = append(keys, bytes.Repeat([]byte{0xaa}, 32)...)
+	keys = append(keys, bytes.Repeat([]byte{0xbb}, 32)...)
+	data = append(data, section(TYPE_NOSPAMKEYS, keys)...)
+
+	data = append(data, section(TYPE_DHT, []byte("dht nodes"))...)
+
+	friends := savedFriend(4, 0x11, "", "alice", "here", 1, 0, 1400000000)
+	friends = append(friends, savedFriend(1, 0x22, "let me in", "", "", 0, 0xdeadbeef, 0)...)
+	data = append(data, section(TYPE_FRIENDS, friends)...)
+
+	data = append(data, section(TYPE_NAME, []byte("bot"))...)
+	data = append(data, section(TYPE_STATUSMESSAGE, []byte("working"))...)
+	data = append(data, section(TYPE_STATUS, []byte{2})...)
+	data = append(data, section(TYPE_END, nil)...)
+
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	p, err := Decode(testState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var publicKey, secretKey Key
+	copy(publicKey[:], bytes.Repeat([]byte{0xaa}, 32))
+	copy(secretKey[:], bytes.Repeat([]byte{0xbb}, 32))
+	var alice, bob Key
+	copy(alice[:], bytes.Repeat([]byte{0x11}, 32))
+	copy(bob[:], bytes.Repeat([]byte{0x22}, 32))
+
+	want := &Profile{
+		Address:       "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA040302010602",
+		Nospam:        0x01020304,
+		PublicKey:     publicKey,
+		SecretKey:     secretKey,
+		Name:          "bot",
+		StatusMessage: "working",
+		UserStatus:    2,
+		Friends: []Friend{
+			{
+				Status:        FRIEND_ONLINE,
+				PublicKey:     alice,
+				Name:          "alice",
+				StatusMessage: "here",
+				UserStatus:    1,
+				LastSeen:      time.Unix(1400000000, 0).UTC(),
+			},
+			{
+				Status:         FRIEND_ADDED,
+				PublicKey:      bob,
+				RequestMessage: "let me in",
+				RequestNospam:  0xdeadbeef,
+			},
+		},
+		Raw: map[uint16][]byte{TYPE_DHT: []byte("dht nodes")},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Decode = %+v, want %+v", p, want)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	state := testState()
+	wrongCookie := bytes.Clone(state)
+	wrongCookie[14] ^= 1
+	badFriends := append(bytes.Clone(state[:8]), section(TYPE_FRIENDS, make([]byte, 100))...)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"short header", state[:4], ErrTruncated},
+		{"wrong magic", []byte("gtox\x00\x01\x00\x00\x00\x00"), ErrFormat},
+		{"encrypted", []byte("toxEsave and more"), ErrEncrypted},
+		{"partial section header", state[:12], ErrTruncated},
+		{"partial section", state[:20], ErrTruncated},
+		{"wrong section cookie", wrongCookie, ErrFormat},
+		{"partial friend", badFriends, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		if _, err := Decode(tt.data); err != tt.err {
+			t.Errorf("%s: Decode = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	p, err := Decode(testState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := Decode(Encode(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encode leaves the friends out
+	p.Friends = nil
+	if !reflect.DeepEqual(q, p) {
+		t.Errorf("Decode(Encode(p)) = %+v, want %+v", q, p)
+	}
+}
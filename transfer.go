@@ -0,0 +1,622 @@
+package golibtox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// AcceptFileFunc is called by Do for each file a friend wants to send.
+// It returns the writer receiving the file, or nil to refuse it. If the
+// writer is an io.Closer, it is closed when the transfer ends.
+type AcceptFileFunc func(tr *Transfer) io.Writer
+
+// How long a transfer broken because the friend went offline waits for
+// the friend by default, see SetBrokenTransferTimeout.
+const BROKEN_TRANSFER_TIMEOUT = 10 * time.Minute
+
+type transferState int
+
+const (
+	// Sender only: waiting for a free filenumber
+	transferQueued transferState = iota
+	// Waiting for the receiver to accept
+	transferPending
+	transferActive
+	transferPaused
+	// Sender only: FINISHED sent, waiting for the receiver to confirm
+	transferFinishing
+	// The friend went offline, waiting for FILECONTROL_RESUME_BROKEN
+	transferBroken
+	transferDone
+)
+
+type transferKey struct {
+	friendNumber int32
+	filenumber   uint8
+	sending      bool
+}
+
+// Transfer is a file transfer handled by the library, started by SendFile
+// or accepted through AcceptFile. Data is read from or written to its
+// io.Reader or io.Writer by Do.
+type Transfer struct {
+	tox          *Tox
+	friendNumber int32
+	filenumber   uint8
+	sending      bool
+	filename     []byte
+	size         uint64
+	publicKey    PublicKey // Receiver only: the sender
+	path         string    // Receiver only: set by Downloader.Accept
+
+	r io.Reader
+	w io.Writer
+
+	mtx         sync.Mutex
+	state       transferState
+	buf         []byte
+	chunk       []byte // Read from r, not accepted by toxcore yet
+	eof         bool
+	offset      uint64
+	skip        uint64 // Receiver only: bytes to drop, already written before a restart
+	transferred uint64
+	checkpoint  uint64    // Receiver only: transferred when last recorded to the state file
+	hash        hash.Hash // SHA-256 of the data so far, nil for a sender without digest
+	verified    bool
+	err         error
+	done        chan struct{}
+	started     time.Time
+	broken      time.Time // When the friend went offline
+	prog        progressState
+}
+
+func newTransfer(t *Tox, friendNumber int32, filenumber uint8, sending bool, filename []byte, size uint64) *Transfer {
+	now := time.Now()
+
+	return &Transfer{
+		tox:          t,
+		friendNumber: friendNumber,
+		filenumber:   filenumber,
+		sending:      sending,
+		filename:     filename,
+		size:         size,
+		done:         make(chan struct{}),
+		started:      now,
+		prog:         progressState{lastSample: now},
+	}
+}
+
+func (tr *Transfer) key() transferKey {
+	return transferKey{tr.friendNumber, tr.filenumber, tr.sending}
+}
+
+func (tr *Transfer) FriendNumber() int32 {
+	return tr.friendNumber
+}
+
+// Filenumber returns the filenumber of the transfer, 0 while it is queued.
+func (tr *Transfer) Filenumber() uint8 {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.filenumber
+}
+
+func (tr *Transfer) Sending() bool {
+	return tr.sending
+}
+
+func (tr *Transfer) Filename() []byte {
+	return tr.filename
+}
+
+func (tr *Transfer) Size() uint64 {
+	return tr.size
+}
+
+// Offset returns the position the transfer started from. It is not zero
+// for an incoming file partially received before a restart, see
+// SetTransferStateFile: the writer returned by AcceptFileFunc must then
+// continue at that position.
+func (tr *Transfer) Offset() uint64 {
+	return tr.offset
+}
+
+// Path returns where the file is saved, when accepted by a Downloader.
+func (tr *Transfer) Path() string {
+	return tr.path
+}
+
+// Done returns a channel closed when the transfer has ended.
+func (tr *Transfer) Done() <-chan struct{} {
+	return tr.done
+}
+
+// Err returns why the transfer failed, or nil while it runs or once it
+// completed successfully.
+func (tr *Transfer) Err() error {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.err
+}
+
+// Wait blocks until the transfer has ended and returns Err.
+func (tr *Transfer) Wait() error {
+	<-tr.done
+	return tr.Err()
+}
+
+// Cancel kills the transfer. Wait then returns ErrTransferCanceled.
+func (tr *Transfer) Cancel() {
+	tr.cancel(ErrTransferCanceled)
+}
+
+func (tr *Transfer) cancel(err error) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	tr.kill(err)
+}
+
+// kill tells the friend to stop the transfer and ends it with err.
+// tr.mtx must be held.
+func (tr *Transfer) kill(err error) {
+	if tr.state == transferDone {
+		return
+	}
+
+	if tr.state != transferQueued {
+		tr.tox.FileSendControl(tr.friendNumber, !tr.sending, tr.filenumber, FILECONTROL_KILL, nil)
+	}
+	tr.finish(err)
+}
+
+// finish ends the transfer with err, nil meaning success.
+// tr.mtx must be held.
+func (tr *Transfer) finish(err error) {
+	if tr.state == transferDone {
+		return
+	}
+
+	if c, ok := tr.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	tr.state = transferDone
+	tr.err = err
+	tr.tox.removeTransfer(tr)
+	// Kept for when the friend sends the file again
+	if !tr.sending && err != ErrClosed && err != ErrTransferBroken {
+		tr.tox.removePartial(tr)
+	}
+	close(tr.done)
+}
+
+// pump hands the next chunk of r to toxcore, if the rate limits allow
+// it, and sends FILECONTROL_FINISHED once r is exhausted. It returns
+// whether it should be called again during this Do.
+func (tr *Transfer) pump() bool {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.state != transferActive {
+		return false
+	}
+
+	if len(tr.chunk) == 0 {
+		if tr.eof {
+			err := tr.tox.FileSendControl(tr.friendNumber, false, tr.filenumber, FILECONTROL_FINISHED, tr.digest())
+			if err == nil {
+				tr.state = transferFinishing
+			}
+			return false
+		}
+
+		if tr.buf == nil {
+			size, err := tr.tox.FileDataSize(tr.friendNumber)
+			if err != nil {
+				tr.kill(err)
+				return false
+			}
+			tr.buf = make([]byte, size)
+		}
+
+		n, err := io.ReadFull(tr.r, tr.buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			tr.eof = true
+		} else if err != nil {
+			tr.kill(err)
+			return false
+		}
+		tr.chunk = tr.buf[:n]
+		if tr.hash != nil {
+			tr.hash.Write(tr.chunk)
+		}
+		if n == 0 {
+			return true
+		}
+	}
+
+	if !tr.tox.sched.allow(tr.friendNumber, len(tr.chunk)) {
+		return false
+	}
+
+	if err := tr.tox.FileSendData(tr.friendNumber, tr.filenumber, tr.chunk); err == ErrFriendNotFound {
+		tr.kill(err)
+		return false
+	} else if err != nil {
+		// Most likely the queue is full, retry on the next Do
+		return false
+	}
+	tr.tox.sched.take(tr.friendNumber, len(tr.chunk))
+	tr.transferred += uint64(len(tr.chunk))
+	tr.chunk = nil
+
+	return true
+}
+
+func (tr *Transfer) control(fileControl FileControl, data []byte) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	switch fileControl {
+	case FILECONTROL_RESUME_BROKEN:
+		if tr.sending && tr.state == transferBroken && len(data) == 8 {
+			tr.resume(binary.NativeEndian.Uint64(data))
+		}
+	case FILECONTROL_ACCEPT:
+		if tr.state == transferPending || tr.state == transferPaused {
+			tr.state = transferActive
+		}
+	case FILECONTROL_PAUSE:
+		if tr.state == transferActive {
+			tr.state = transferPaused
+		}
+	case FILECONTROL_KILL:
+		tr.finish(ErrTransferKilled)
+	case FILECONTROL_FINISHED:
+		if !tr.sending {
+			if err := tr.verify(data); err != nil {
+				tr.kill(err)
+				return
+			}
+			// Confirm to the sender that everything was received
+			tr.tox.FileSendControl(tr.friendNumber, true, tr.filenumber, FILECONTROL_FINISHED, nil)
+		}
+		tr.finish(nil)
+	}
+}
+
+func (tr *Transfer) write(data []byte) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.state != transferActive {
+		return
+	}
+
+	// The skipped bytes are sent again from the start, hash them too
+	tr.hash.Write(data)
+
+	if tr.skip > 0 {
+		n := uint64(len(data))
+		if n > tr.skip {
+			n = tr.skip
+		}
+		tr.skip -= n
+		data = data[n:]
+	}
+
+	if _, err := tr.w.Write(data); err != nil {
+		tr.kill(err)
+		return
+	}
+	tr.transferred += uint64(len(data))
+
+	if tr.transferred-tr.checkpoint >= checkpointInterval {
+		tr.tox.savePartial(tr)
+		tr.checkpoint = tr.transferred
+	}
+}
+
+// brk marks the transfer as broken when the friend goes offline.
+// toxcore keeps the file slot so that it can be resumed.
+func (tr *Transfer) brk() {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.state == transferDone {
+		return
+	}
+
+	tr.state = transferBroken
+	tr.broken = time.Now()
+	if !tr.sending {
+		tr.tox.savePartial(tr)
+		tr.checkpoint = tr.transferred
+	}
+}
+
+// requestResume asks the sender, back online, to continue a broken
+// incoming transfer from the bytes written so far.
+func (tr *Transfer) requestResume() {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.sending || tr.state != transferBroken {
+		return
+	}
+
+	data := make([]byte, 8)
+	binary.NativeEndian.PutUint64(data, tr.transferred)
+	if err := tr.tox.FileSendControl(tr.friendNumber, true, tr.filenumber, FILECONTROL_RESUME_BROKEN, data); err != nil {
+		tr.kill(err)
+		return
+	}
+
+	// The sender answers with FILECONTROL_ACCEPT
+	tr.state = transferPending
+}
+
+// resume restarts sending from position, as requested by the receiver.
+// tr.mtx must be held.
+func (tr *Transfer) resume(position uint64) {
+	seeker, ok := tr.r.(io.Seeker)
+	if !ok {
+		tr.kill(ErrNotResumable)
+		return
+	}
+
+	var err error
+	if tr.hash != nil {
+		err = tr.rehash(seeker, position)
+	} else {
+		_, err = seeker.Seek(int64(position), io.SeekStart)
+	}
+	if err != nil {
+		tr.kill(err)
+		return
+	}
+
+	tr.chunk = nil
+	tr.eof = false
+	tr.transferred = position
+
+	if err := tr.tox.FileSendControl(tr.friendNumber, false, tr.filenumber, FILECONTROL_ACCEPT, nil); err != nil {
+		tr.kill(err)
+		return
+	}
+	tr.state = transferActive
+}
+
+// SendFile offers the size bytes read from r to friendNumber as filename.
+// The transfer starts once the friend accepts it, and is canceled when
+// ctx is done. If every filenumber with friendNumber is busy, it is
+// queued and offered by Do once one is free.
+func (t *Tox) SendFile(ctx context.Context, friendNumber int32, filename string, r io.Reader, size uint64) (*Transfer, error) {
+	if len(filename) == 0 {
+		return nil, ErrEmptyFilename
+	}
+
+	if err := t.setFileHooks(); err != nil {
+		return nil, err
+	}
+
+	tr := newTransfer(t, friendNumber, 0, true, []byte(filename), size)
+	tr.r = r
+
+	// Hold the map until the transfer is in it, so that a control
+	// received in the meantime by another goroutine is not missed.
+	t.trmtx.Lock()
+	if t.sendDigests {
+		tr.hash = sha256.New()
+	}
+	if len(t.queued[friendNumber]) > 0 {
+		// Keep the order of the sends
+		t.enqueue(tr)
+	} else if err := t.offer(tr); err == ErrNoFileSlot {
+		t.enqueue(tr)
+	} else if err != nil {
+		t.trmtx.Unlock()
+		return nil, err
+	}
+	t.trmtx.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				tr.cancel(ctx.Err())
+			case <-tr.done:
+			}
+		}()
+	}
+
+	return tr, nil
+}
+
+// AcceptFile sets the func deciding which incoming files are received by
+// the library. Requests are still passed to CallbackFileSendRequest.
+func (t *Tox) AcceptFile(f AcceptFileFunc) error {
+	t.trmtx.Lock()
+	t.acceptFileFunc = f
+	t.trmtx.Unlock()
+
+	return t.setFileHooks()
+}
+
+func (t *Tox) transfer(friendNumber int32, filenumber uint8, sending bool) *Transfer {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	return t.transfers[transferKey{friendNumber, filenumber, sending}]
+}
+
+func (t *Tox) removeTransfer(tr *Transfer) {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	if t.transfers[tr.key()] == tr {
+		delete(t.transfers, tr.key())
+	} else if !t.dequeue(tr) {
+		return
+	}
+	// Reported as done by the next Do
+	t.finished = append(t.finished, tr)
+}
+
+// friendTransfers returns the transfers with friendNumber, or all of them
+// if friendNumber is -1.
+func (t *Tox) friendTransfers(friendNumber int32) []*Transfer {
+	t.trmtx.Lock()
+	defer t.trmtx.Unlock()
+
+	var trs []*Transfer
+	for _, tr := range t.transfers {
+		if friendNumber == -1 || tr.friendNumber == friendNumber {
+			trs = append(trs, tr)
+		}
+	}
+
+	return trs
+}
+
+// The functions below are called by Do, from the queued hooks.
+
+func (t *Tox) handleFileSendRequest(friendNumber int32, filenumber uint8, filesize uint64, filename []byte) {
+	t.trmtx.Lock()
+	accept := t.acceptFileFunc
+	t.trmtx.Unlock()
+
+	if accept == nil {
+		return
+	}
+
+	publicKey, err := t.GetClientId(friendNumber)
+	if err != nil {
+		return
+	}
+
+	tr := newTransfer(t, friendNumber, filenumber, false, filename, filesize)
+	tr.publicKey = publicKey
+	tr.hash = sha256.New()
+	if p := t.partial(publicKey, filename, filesize); p != nil {
+		// toxcore cannot resume a transfer from a previous run, the file
+		// is sent again from the start: drop what was already written.
+		tr.offset = p.Received
+		tr.skip = p.Received
+		tr.transferred = p.Received
+		tr.checkpoint = p.Received
+		tr.prog.lastTransferred = p.Received
+		tr.path = p.Path
+	}
+	tr.w = accept(tr)
+	if tr.w == nil {
+		t.FileSendControl(friendNumber, true, filenumber, FILECONTROL_KILL, nil)
+		return
+	}
+
+	tr.state = transferActive
+	t.trmtx.Lock()
+	t.transfers[tr.key()] = tr
+	t.trmtx.Unlock()
+
+	tr.mtx.Lock()
+	t.savePartial(tr)
+	tr.mtx.Unlock()
+
+	if err := t.FileSendControl(friendNumber, true, filenumber, FILECONTROL_ACCEPT, nil); err != nil {
+		tr.cancel(err)
+	}
+}
+
+func (t *Tox) handleFileControl(friendNumber int32, sending bool, filenumber uint8, fileControl FileControl, data []byte) {
+	if tr := t.transfer(friendNumber, filenumber, sending); tr != nil {
+		tr.control(fileControl, data)
+	}
+}
+
+func (t *Tox) handleFileData(friendNumber int32, filenumber uint8, data []byte) {
+	if tr := t.transfer(friendNumber, filenumber, false); tr != nil {
+		tr.write(data)
+	}
+}
+
+func (t *Tox) handleConnectionStatus(friendNumber int32, status bool) {
+	for _, tr := range t.friendTransfers(friendNumber) {
+		if status {
+			tr.requestResume()
+		} else {
+			tr.brk()
+		}
+	}
+}
+
+// SetBrokenTransferTimeout sets how long a transfer broken because the
+// friend went offline waits for the friend to come back,
+// BROKEN_TRANSFER_TIMEOUT by default, 0 meaning forever. Past it, the
+// transfer ends with ErrTransferBroken and frees its file slot. An
+// incoming one stays recorded in the transfer state file.
+func (t *Tox) SetBrokenTransferTimeout(timeout time.Duration) {
+	t.trmtx.Lock()
+	t.brokenTimeout = timeout
+	t.trmtx.Unlock()
+}
+
+// expireBroken is called by Do to end the transfers broken for longer
+// than the timeout.
+func (t *Tox) expireBroken() {
+	t.trmtx.Lock()
+	timeout := t.brokenTimeout
+	t.trmtx.Unlock()
+
+	if timeout == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, tr := range t.friendTransfers(-1) {
+		tr.mtx.Lock()
+		if tr.state == transferBroken && now.Sub(tr.broken) >= timeout {
+			tr.kill(ErrTransferBroken)
+		}
+		tr.mtx.Unlock()
+	}
+}
+
+// endFriendTransfers ends the transfers with friendNumber, queued or not,
+// with err.
+func (t *Tox) endFriendTransfers(friendNumber int32, err error) {
+	trs := t.friendTransfers(friendNumber)
+	for _, tr := range t.queuedTransfers() {
+		if tr.friendNumber == friendNumber {
+			trs = append(trs, tr)
+		}
+	}
+
+	for _, tr := range trs {
+		tr.cancel(err)
+	}
+}
+
+// closeTransfers ends every transfer once t has been closed.
+func (t *Tox) closeTransfers() {
+	for _, tr := range append(t.friendTransfers(-1), t.queuedTransfers()...) {
+		tr.mtx.Lock()
+		if !tr.sending {
+			t.savePartial(tr)
+		}
+		tr.finish(ErrClosed)
+		tr.mtx.Unlock()
+
+		tr.notifyProgress(time.Now())
+	}
+}